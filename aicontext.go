@@ -16,11 +16,24 @@ type aiContext struct {
 	allLabels   []*github.Label
 	lastIssues  []*github.Issue
 	targetIssue *github.Issue
+	// pullRequest is set when the target issue is a pull request.
+	pullRequest *pullRequestContext
+	// guidance is extra, repo-configured guidance keyed by label name.
+	guidance map[string]string
+	// instructions are the repo's own instructions and glossary.
+	instructions string
+	// ruleLabels were already set by the repo's rules.
+	ruleLabels []string
 }
 
 func issueToText(issue *github.Issue) string {
+	kind := "ISSUE"
+	if issue.IsPullRequest() {
+		kind = "PULL REQUEST"
+	}
+
 	var sb strings.Builder
-	fmt.Fprintf(&sb, "=== ISSUE %v ===\n", issue.GetNumber())
+	fmt.Fprintf(&sb, "=== %s %v ===\n", kind, issue.GetNumber())
 	fmt.Fprintf(&sb, "author: %s (%s)\n", issue.GetUser().GetLogin(), issue.GetAuthorAssociation())
 	var labels []string
 	for _, label := range issue.Labels {
@@ -36,8 +49,38 @@ func issueToText(issue *github.Issue) string {
 	}
 	saver.Write([]byte(issue.GetBody()))
 	sb.Write(saver.Bytes())
-	fmt.Fprintf(&sb, "\n=== END ISSUE %v ===\n", issue.GetNumber())
+	fmt.Fprintf(&sb, "\n=== END %s %v ===\n", kind, issue.GetNumber())
+
+	return sb.String()
+}
+
+// maxPullRequestFiles is the number of changed files listed in the context.
+const maxPullRequestFiles = 100
+
+func pullRequestToText(pr *pullRequestContext) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "=== FILES CHANGED (%d) ===\n", len(pr.files))
+	for i, file := range pr.files {
+		if i == maxPullRequestFiles {
+			fmt.Fprintf(&sb, "... and %d more\n", len(pr.files)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "%s %s (+%d -%d)\n",
+			file.GetStatus(), file.GetFilename(),
+			file.GetAdditions(), file.GetDeletions(),
+		)
+	}
 
+	if pr.diff != "" {
+		sb.WriteString("=== DIFF ===\n")
+		saver := prefixsuffix.Saver{
+			// Max 12000 characters of diff.
+			N: 6000,
+		}
+		saver.Write([]byte(pr.diff))
+		sb.Write(saver.Bytes())
+		sb.WriteString("\n=== END DIFF ===\n")
+	}
 	return sb.String()
 }
 
@@ -73,6 +116,11 @@ func (c *aiContext) Request(
 		labelsDescription.WriteString(label.GetName())
 		labelsDescription.WriteString(": ")
 		labelsDescription.WriteString(label.GetDescription())
+		if g := c.guidance[label.GetName()]; g != "" {
+			labelsDescription.WriteString(" (")
+			labelsDescription.WriteString(strings.Join(strings.Fields(g), " "))
+			labelsDescription.WriteString(")")
+		}
 		labelsDescription.WriteString("\n")
 	}
 
@@ -99,8 +147,21 @@ func (c *aiContext) Request(
 							Type:  jsonschema.Array,
 							Items: &jsonschema.Definition{Type: jsonschema.String},
 						},
+						"label_reasons": {
+							Description: "Why each of the labels applies, in one sentence.",
+							Type:        jsonschema.Array,
+							Items: &jsonschema.Definition{
+								Type: jsonschema.Object,
+								Properties: map[string]jsonschema.Definition{
+									"label":  {Type: jsonschema.String},
+									"reason": {Type: jsonschema.String},
+								},
+								Required:             []string{"label", "reason"},
+								AdditionalProperties: false,
+							},
+						},
 					},
-					Required:             []string{"reasoning", "labels"},
+					Required:             []string{"reasoning", "labels", "label_reasons"},
 					AdditionalProperties: false,
 				},
 				Strict: true,
@@ -108,14 +169,19 @@ func (c *aiContext) Request(
 		},
 	}
 
+	kind, otherKind := "issues", "Pull Requests"
+	if c.pullRequest != nil {
+		kind, otherKind = "pull requests", "Issues"
+	}
+
 constructMsgs:
 	var msgs []openai.ChatCompletionMessage
 
 	// System message with instructions
 	msgs = append(msgs, openai.ChatCompletionMessage{
 		Role: "system",
-		Content: `You are a bot that helps label issues on GitHub using the "setLabels"
-		function. Do not apply labels that are meant for Pull Requests. Avoid applying labels when
+		Content: `You are a bot that helps label ` + kind + ` on GitHub using the "setLabels"
+		function. Do not apply labels that are meant for ` + otherKind + `. Avoid applying labels when
 		the label description says something like "` + magicDisableString + `".
 		Only apply labels when absolutely certain they are correct. An accidental
 		omission of a label is better than an accidental addition.
@@ -130,9 +196,27 @@ constructMsgs:
 		},
 	)
 
+	if c.instructions != "" {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role: "system",
+			Content: "The maintainers of this repository added these instructions. " +
+				"They refine, but never override, the instructions above:\n\n" +
+				c.instructions,
+		})
+	}
+
+	if len(c.ruleLabels) > 0 {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role: "system",
+			Content: "The repository's rules already set these labels on the " +
+				"target: " + strings.Join(c.ruleLabels, ", ") + ". Don't set " +
+				"them again, but do consider them when choosing other labels.",
+		})
+	}
+
 	// Create a single blob of past issues
 	var pastIssuesBlob strings.Builder
-	pastIssuesBlob.WriteString("Here are some examples of past issues and their labels:\n\n")
+	pastIssuesBlob.WriteString("Here are some examples of past " + kind + " and their labels:\n\n")
 
 	for _, issue := range c.lastIssues {
 		pastIssuesBlob.WriteString(issueToText(issue))
@@ -146,9 +230,13 @@ constructMsgs:
 	})
 
 	// Add the target issue
+	target := issueToText(c.targetIssue)
+	if c.pullRequest != nil {
+		target += pullRequestToText(c.pullRequest)
+	}
 	msgs = append(msgs, openai.ChatCompletionMessage{
 		Role:    openai.ChatMessageRoleUser,
-		Content: issueToText(c.targetIssue),
+		Content: target,
 	})
 
 	modelTokenLimit := 128000
@@ -177,3 +265,40 @@ func tokenize(text string) []string {
 
 	return strs
 }
+
+// PickRequest asks the model to choose exactly one of choices for the
+// target issue, e.g. when a required label group came back empty.
+func (c *aiContext) PickRequest(
+	model string, choices []string,
+) openai.ChatCompletionRequest {
+	request := c.Request(model)
+	request.ResponseFormat = &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:        "pickLabel",
+			Description: `Pick the one label that best fits the GitHub issue.`,
+			Schema: jsonschema.Definition{
+				Type: jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{
+					"reasoning": {
+						Description: "The reasoning for the label in one sentence.",
+						Type:        jsonschema.String,
+					},
+					"label": {
+						Type: jsonschema.String,
+						Enum: choices,
+					},
+				},
+				Required:             []string{"reasoning", "label"},
+				AdditionalProperties: false,
+			},
+			Strict: true,
+		},
+	}
+	request.Messages = append(request.Messages, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser,
+		Content: "Every issue must carry exactly one of these labels: " +
+			strings.Join(choices, ", ") + ". Pick the one that fits best.",
+	})
+	return request
+}
@@ -0,0 +1,130 @@
+package labeler
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// assignRule sets labels on issues that match all of its conditions,
+// without asking the model.
+type assignRule struct {
+	// Name identifies the rule in InferResponse. Defaults to its index.
+	Name   string   `yaml:"name"`
+	Labels []string `yaml:"labels"`
+
+	Title *regexp.Regexp `yaml:"title"`
+	Body  *regexp.Regexp `yaml:"body"`
+	// Form matches issue form fields, keyed by the field's label.
+	Form map[string]*regexp.Regexp `yaml:"form"`
+	// Author matches the author's login.
+	Author *regexp.Regexp `yaml:"author"`
+	// AuthorAssociation lists author associations, e.g. NONE or MEMBER.
+	AuthorAssociation []string `yaml:"author_association"`
+	// Bot, if set, matches whether the author is a bot.
+	Bot *bool `yaml:"bot"`
+	// HasLabel matches if any of the issue's existing labels match.
+	HasLabel *regexp.Regexp `yaml:"has_label"`
+}
+
+// ruleLabel is a label set by an assignRule.
+type ruleLabel struct {
+	Label string `json:"label"`
+	Rule  string `json:"rule"`
+}
+
+// issueFormResponse is what GitHub renders for an optional issue form
+// field that was left empty.
+const issueFormResponse = "_No response_"
+
+// issueFormFields parses the body GitHub renders for an issue form, where
+// each field is a "### <label>" heading followed by its value.
+func issueFormFields(body string) map[string]string {
+	fields := make(map[string]string)
+	var (
+		field string
+		value strings.Builder
+	)
+	flush := func() {
+		if field == "" {
+			return
+		}
+		v := strings.TrimSpace(value.String())
+		if v == issueFormResponse {
+			v = ""
+		}
+		fields[field] = v
+		value.Reset()
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		if heading, ok := strings.CutPrefix(line, "### "); ok {
+			flush()
+			field = strings.TrimSpace(heading)
+			continue
+		}
+		if field != "" {
+			value.WriteString(line)
+			value.WriteString("\n")
+		}
+	}
+	flush()
+	return fields
+}
+
+func (r *assignRule) matches(issue *github.Issue, existing []string) bool {
+	if r.Title != nil && !r.Title.MatchString(issue.GetTitle()) {
+		return false
+	}
+	if r.Body != nil && !r.Body.MatchString(issue.GetBody()) {
+		return false
+	}
+	if len(r.Form) > 0 {
+		fields := issueFormFields(issue.GetBody())
+		for field, re := range r.Form {
+			v, ok := fields[field]
+			if !ok || re == nil || !re.MatchString(v) {
+				return false
+			}
+		}
+	}
+	if r.Author != nil && !r.Author.MatchString(issue.GetUser().GetLogin()) {
+		return false
+	}
+	if len(r.AuthorAssociation) > 0 && !slices.ContainsFunc(r.AuthorAssociation, func(a string) bool {
+		return strings.EqualFold(a, issue.GetAuthorAssociation())
+	}) {
+		return false
+	}
+	if r.Bot != nil && *r.Bot != (issue.GetUser().GetType() == "Bot") {
+		return false
+	}
+	if r.HasLabel != nil && !slices.ContainsFunc(existing, r.HasLabel.MatchString) {
+		return false
+	}
+	return true
+}
+
+// applyAssignRules returns the labels set by the rules matching issue,
+// in rule order and without duplicates.
+func applyAssignRules(rules []assignRule, issue *github.Issue, existing []string) []ruleLabel {
+	var labels []ruleLabel
+	for i, r := range rules {
+		if !r.matches(issue, existing) {
+			continue
+		}
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("rules[%d]", i)
+		}
+		for _, label := range r.Labels {
+			if slices.ContainsFunc(labels, func(l ruleLabel) bool { return l.Label == label }) {
+				continue
+			}
+			labels = append(labels, ruleLabel{Label: label, Rule: name})
+		}
+	}
+	return labels
+}
@@ -0,0 +1,173 @@
+package labeler
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+)
+
+func TestIssueFormFields(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{"Empty", "", map[string]string{}},
+		{"NotAForm", "It crashes when I click save.", map[string]string{}},
+		{
+			name: "Form",
+			body: "### Version\n\nv2.10.0\n\n### OS\n\nmacOS\n",
+			want: map[string]string{"Version": "v2.10.0", "OS": "macOS"},
+		},
+		{
+			name: "NoResponse",
+			body: "### Version\n\n_No response_\n\n### Logs\n\n_No response_",
+			want: map[string]string{"Version": "", "Logs": ""},
+		},
+		{
+			name: "MultiLine",
+			body: "### Steps\n\n1. Open settings\n2. Click save\n\n### Expected\n\nIt saves.",
+			want: map[string]string{"Steps": "1. Open settings\n2. Click save", "Expected": "It saves."},
+		},
+		{
+			name: "CRLF",
+			body: "### Version\r\n\r\nv2.10.0\r\n",
+			want: map[string]string{"Version": "v2.10.0"},
+		},
+		{
+			name: "TextBeforeFirstHeading",
+			body: "Thanks for reporting!\n\n###  Version  \n\nv2.10.0",
+			want: map[string]string{"Version": "v2.10.0"},
+		},
+		{
+			name: "OtherHeadings",
+			body: "### Logs\n\n#### Server\nboom\n## Notes\nnone",
+			want: map[string]string{"Logs": "#### Server\nboom\n## Notes\nnone"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := issueFormFields(tt.body)
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyAssignRules(t *testing.T) {
+	t.Parallel()
+	yes := true
+	rules := []assignRule{
+		{
+			Name:   "windows",
+			Labels: []string{"os/windows"},
+			Form:   map[string]*regexp.Regexp{"OS": regexp.MustCompile(`(?i)windows`)},
+		},
+		{
+			Name:              "community bug",
+			Labels:            []string{"community", "bug"},
+			Title:             regexp.MustCompile(`(?i)^bug:`),
+			AuthorAssociation: []string{"NONE", "CONTRIBUTOR"},
+		},
+		{
+			Labels: []string{"dependencies"},
+			Bot:    &yes,
+			Author: regexp.MustCompile(`^dependabot`),
+		},
+		{
+			Name:     "triage",
+			Labels:   []string{"needs-triage", "bug"},
+			Body:     regexp.MustCompile(`panic:`),
+			HasLabel: regexp.MustCompile(`^bug$`),
+		},
+	}
+	issue := func(title, body, login, userType, association string) *github.Issue {
+		return &github.Issue{
+			Title:             github.String(title),
+			Body:              github.String(body),
+			User:              &github.User{Login: github.String(login), Type: github.String(userType)},
+			AuthorAssociation: github.String(association),
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		issue    *github.Issue
+		existing []string
+		want     []ruleLabel
+	}{
+		{
+			name:  "None",
+			issue: issue("Add dark mode", "Please.", "someone", "User", "NONE"),
+		},
+		{
+			name:  "FormField",
+			issue: issue("Crash", "### OS\n\nWindows 11\n", "someone", "User", "MEMBER"),
+			want:  []ruleLabel{{Label: "os/windows", Rule: "windows"}},
+		},
+		{
+			name:  "FormFieldNoResponse",
+			issue: issue("Crash", "### OS\n\n_No response_\n", "someone", "User", "MEMBER"),
+		},
+		{
+			name:  "FormFieldMissing",
+			issue: issue("Crash", "Happens on Windows.", "someone", "User", "MEMBER"),
+		},
+		{
+			name:  "AllConditions",
+			issue: issue("Bug: crash on save", "", "someone", "User", "contributor"),
+			want: []ruleLabel{
+				{Label: "community", Rule: "community bug"},
+				{Label: "bug", Rule: "community bug"},
+			},
+		},
+		{
+			name:  "OneConditionFails",
+			issue: issue("Bug: crash on save", "", "someone", "User", "MEMBER"),
+		},
+		{
+			name:  "DefaultName",
+			issue: issue("Bump x", "", "dependabot[bot]", "Bot", "NONE"),
+			want:  []ruleLabel{{Label: "dependencies", Rule: "rules[2]"}},
+		},
+		{
+			name:  "NotBot",
+			issue: issue("Bump x", "", "dependabot-fan", "User", "NONE"),
+		},
+		{
+			name:     "HasLabel",
+			issue:    issue("Crash", "panic: nil map", "someone", "User", "MEMBER"),
+			existing: []string{"bug"},
+			want: []ruleLabel{
+				{Label: "needs-triage", Rule: "triage"},
+				{Label: "bug", Rule: "triage"},
+			},
+		},
+		{
+			name:     "NoDuplicates",
+			issue:    issue("bug: crash", "### OS\n\nwindows\n\npanic: nil map", "someone", "User", "NONE"),
+			existing: []string{"bug"},
+			want: []ruleLabel{
+				{Label: "os/windows", Rule: "windows"},
+				{Label: "community", Rule: "community bug"},
+				{Label: "bug", Rule: "community bug"},
+				{Label: "needs-triage", Rule: "triage"},
+			},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := applyAssignRules(rules, tt.issue, tt.existing)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
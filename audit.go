@@ -0,0 +1,321 @@
+package labeler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/coder/labeler/httpjson"
+	"github.com/sashabaranov/go-openai"
+	"google.golang.org/api/iterator"
+)
+
+// AuditRecord is one labeling decision: what the model was asked, what it
+// answered, and what became of its labels.
+type AuditRecord struct {
+	At          time.Time `json:"at" bigquery:"at"`
+	InstallID   string    `json:"install_id" bigquery:"install_id"`
+	User        string    `json:"user" bigquery:"user"`
+	Repo        string    `json:"repo" bigquery:"repo"`
+	Issue       int       `json:"issue" bigquery:"issue"`
+	PullRequest bool      `json:"pull_request" bigquery:"pull_request"`
+	TestMode    bool      `json:"test_mode" bigquery:"test_mode"`
+
+	Model string `json:"model" bigquery:"model"`
+	// PromptHash is the SHA-256 of the messages sent to the model, so
+	// decisions made from the same prompt can be grouped.
+	PromptHash       string `json:"prompt_hash" bigquery:"prompt_hash"`
+	PromptTokens     int    `json:"prompt_tokens" bigquery:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens" bigquery:"completion_tokens"`
+	// TotalTokens includes follow-up requests, e.g. for requires_one_of.
+	TotalTokens int    `json:"total_tokens" bigquery:"total_tokens"`
+	RawOutput   string `json:"raw_output" bigquery:"raw_output"`
+
+	// The labels after each stage of filtering, in order.
+	ModelLabels []string `json:"model_labels" bigquery:"model_labels"`
+	// AfterDisabled drops disabled labels and those the rules set.
+	AfterDisabled   []string `json:"after_disabled" bigquery:"after_disabled"`
+	AfterRepo       []string `json:"after_repo" bigquery:"after_repo"`
+	AfterConfidence []string `json:"after_confidence" bigquery:"after_confidence"`
+	// AfterRules adds rule labels and applies the label rules and
+	// exclusive groups. It is the response's SetLabels.
+	AfterRules []string `json:"after_rules" bigquery:"after_rules"`
+	RuleLabels []string `json:"rule_labels" bigquery:"rule_labels"`
+
+	// Applied and Removed are the labels actually added to and removed
+	// from the issue. They are empty when the decision wasn't acted on.
+	Applied []string `json:"applied" bigquery:"applied"`
+	Removed []string `json:"removed" bigquery:"removed"`
+
+	LatencyMS int64 `json:"latency_ms" bigquery:"latency_ms"`
+}
+
+// Reasoning returns the model's explanation of its labels, from its raw
+// output.
+func (rec AuditRecord) Reasoning() string {
+	var out struct {
+		Reasoning string `json:"reasoning"`
+	}
+	_ = json.Unmarshal([]byte(rec.RawOutput), &out)
+	return out.Reasoning
+}
+
+// AuditStore persists every labeling decision.
+type AuditStore interface {
+	RecordDecision(ctx context.Context, rec AuditRecord) error
+	// ListDecisions returns up to limit decisions in the repo, newest
+	// first. If issue is not 0, only decisions on that issue are listed.
+	ListDecisions(ctx context.Context, user, repo string, issue, limit int) ([]AuditRecord, error)
+}
+
+// promptHash identifies the messages of a chat completion request.
+func promptHash(messages []openai.ChatCompletionMessage) string {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// recordDecision writes rec to the audit log, if there is one. Failures
+// are only logged, since the decision has already been made.
+func (s *Webhook) recordDecision(ctx context.Context, rec *AuditRecord) {
+	if s.Audit == nil || rec == nil {
+		return
+	}
+	err := s.Audit.RecordDecision(ctx, *rec)
+	if err != nil {
+		s.Log.Error("record decision",
+			"repo", rec.User+"/"+rec.Repo,
+			"issue", rec.Issue,
+			"error", err,
+		)
+	}
+}
+
+// auditSchema holds one row per decision. Label lists are JSON arrays.
+const auditSchema = `
+CREATE TABLE IF NOT EXISTS decisions (
+	at                INTEGER NOT NULL, -- Unix nanoseconds.
+	install_id        TEXT NOT NULL,
+	user              TEXT NOT NULL COLLATE NOCASE,
+	repo              TEXT NOT NULL COLLATE NOCASE,
+	issue             INTEGER NOT NULL,
+	pull_request      INTEGER NOT NULL,
+	test_mode         INTEGER NOT NULL,
+	model             TEXT NOT NULL,
+	prompt_hash       TEXT NOT NULL,
+	prompt_tokens     INTEGER NOT NULL,
+	completion_tokens INTEGER NOT NULL,
+	total_tokens      INTEGER NOT NULL,
+	raw_output        TEXT NOT NULL,
+	model_labels      TEXT NOT NULL,
+	after_disabled    TEXT NOT NULL,
+	after_repo        TEXT NOT NULL,
+	after_confidence  TEXT NOT NULL,
+	after_rules       TEXT NOT NULL,
+	rule_labels       TEXT NOT NULL,
+	applied           TEXT NOT NULL,
+	removed           TEXT NOT NULL,
+	latency_ms        INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS decisions_by_issue ON decisions (user, repo, issue, at);
+`
+
+// auditColumns are the columns of auditSchema, in order.
+const auditColumns = `at, install_id, user, repo, issue, pull_request, test_mode,
+	model, prompt_hash, prompt_tokens, completion_tokens, total_tokens, raw_output,
+	model_labels, after_disabled, after_repo, after_confidence, after_rules,
+	rule_labels, applied, removed, latency_ms`
+
+type sqliteAuditStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteAuditStore stores decisions in a SQLite database in dir.
+func NewSQLiteAuditStore(dir string) (AuditStore, error) {
+	db, err := openSQLite(filepath.Join(dir, "decisions.db"), auditSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteAuditStore{db: db}, nil
+}
+
+// auditFields returns pointers to rec's fields in auditColumns order, for
+// both inserting and scanning. The at column is Unix nanoseconds.
+func auditFields(rec *AuditRecord, at *int64) []any {
+	return []any{
+		at, &rec.InstallID, &rec.User, &rec.Repo, &rec.Issue, &rec.PullRequest, &rec.TestMode,
+		&rec.Model, &rec.PromptHash, &rec.PromptTokens, &rec.CompletionTokens, &rec.TotalTokens, &rec.RawOutput,
+		jsonColumn[[]string]{&rec.ModelLabels},
+		jsonColumn[[]string]{&rec.AfterDisabled},
+		jsonColumn[[]string]{&rec.AfterRepo},
+		jsonColumn[[]string]{&rec.AfterConfidence},
+		jsonColumn[[]string]{&rec.AfterRules},
+		jsonColumn[[]string]{&rec.RuleLabels},
+		jsonColumn[[]string]{&rec.Applied},
+		jsonColumn[[]string]{&rec.Removed},
+		&rec.LatencyMS,
+	}
+}
+
+func (s *sqliteAuditStore) RecordDecision(ctx context.Context, rec AuditRecord) error {
+	at := rec.At.UnixNano()
+	args := auditFields(&rec, &at)
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO decisions ("+auditColumns+") VALUES (?"+strings.Repeat(", ?", len(args)-1)+")",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("insert decision: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteAuditStore) ListDecisions(ctx context.Context, user, repo string, issue, limit int) ([]AuditRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+auditColumns+`
+	FROM decisions
+	WHERE user = ? AND repo = ? AND (? = 0 OR issue = ?)
+	ORDER BY at DESC, rowid DESC
+	LIMIT ?
+	`, user, repo, issue, issue, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query decisions: %w", err)
+	}
+	defer rows.Close()
+
+	var recs []AuditRecord
+	for rows.Next() {
+		var (
+			rec AuditRecord
+			at  int64
+		)
+		err := rows.Scan(auditFields(&rec, &at)...)
+		if err != nil {
+			return nil, fmt.Errorf("read decision: %w", err)
+		}
+		rec.At = time.Unix(0, at)
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// decisionsTableName is incremented with major schema changes, like
+// issuesTableName. Its schema is bigquery.InferSchema(AuditRecord{}).
+const decisionsTableName = "decisions_v1"
+
+type bigQueryAuditStore struct {
+	client *bigquery.Client
+}
+
+// NewBigQueryAuditStore stores decisions in the ghindex dataset.
+func NewBigQueryAuditStore(client *bigquery.Client) AuditStore {
+	return &bigQueryAuditStore{client: client}
+}
+
+func (s *bigQueryAuditStore) RecordDecision(ctx context.Context, rec AuditRecord) error {
+	err := s.client.Dataset("ghindex").Table(decisionsTableName).Inserter().Put(ctx, rec)
+	if err != nil {
+		return fmt.Errorf("insert decision: %w", err)
+	}
+	return nil
+}
+
+func (s *bigQueryAuditStore) ListDecisions(ctx context.Context, user, repo string, issue, limit int) ([]AuditRecord, error) {
+	q := s.client.Query(`
+	SELECT *
+	FROM ` + "`coder-labeler.ghindex." + decisionsTableName + "`" + `
+	WHERE LOWER(user) = LOWER(@user) AND LOWER(repo) = LOWER(@repo)
+	  AND (@issue = 0 OR issue = @issue)
+	ORDER BY at DESC
+	LIMIT @limit
+	`)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "user", Value: user},
+		{Name: "repo", Value: repo},
+		{Name: "issue", Value: issue},
+		{Name: "limit", Value: limit},
+	}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("run query: %w", err)
+	}
+	iter, err := job.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read query: %w", err)
+	}
+
+	var recs []AuditRecord
+	for {
+		var rec AuditRecord
+		err := iter.Next(&rec)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read decision: %w", err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// maxListDecisions bounds the decisions endpoint's limit.
+const maxListDecisions = 1000
+
+// listDecisions serves the audit log of a repo, or of one of its issues.
+func (s *Webhook) listDecisions(w http.ResponseWriter, r *http.Request) *httpjson.Response {
+	if s.Audit == nil {
+		return &httpjson.Response{
+			Status: http.StatusNotFound,
+			Body:   httpjson.M{"error": "audit log is not enabled"},
+		}
+	}
+
+	var (
+		user  = r.URL.Query().Get("user")
+		repo  = r.URL.Query().Get("repo")
+		issue int
+		limit = 100
+		err   error
+	)
+	if v := r.URL.Query().Get("issue"); v != "" {
+		issue, err = strconv.Atoi(v)
+		if err != nil || issue <= 0 {
+			return &httpjson.Response{
+				Status: http.StatusBadRequest,
+				Body:   httpjson.M{"error": "issue must be a positive number"},
+			}
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxListDecisions {
+			return &httpjson.Response{
+				Status: http.StatusBadRequest,
+				Body:   httpjson.M{"error": fmt.Sprintf("limit must be between 1 and %d", maxListDecisions)},
+			}
+		}
+	}
+
+	recs, err := s.Audit.ListDecisions(r.Context(), user, repo, issue, limit)
+	if err != nil {
+		return s.serverError(err)
+	}
+	return &httpjson.Response{
+		Status: http.StatusOK,
+		Body:   recs,
+	}
+}
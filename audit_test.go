@@ -0,0 +1,77 @@
+package labeler
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSQLiteAuditStore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewSQLiteAuditStore(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, rec := range []AuditRecord{
+		{User: "coder", Repo: "coder", Issue: 1, ModelLabels: []string{"bug", "docs"}, Applied: []string{"bug"}},
+		{User: "coder", Repo: "coder", Issue: 2, PullRequest: true, TestMode: true},
+		{User: "coder", Repo: "coder", Issue: 1, Model: "gpt-4o", TotalTokens: 1200, LatencyMS: 850,
+			RawOutput: `{"reasoning": "It crashes.", "labels": ["bug"]}`},
+		{User: "coder", Repo: "vscode-coder", Issue: 1},
+	} {
+		rec.At = at.Add(time.Duration(i) * time.Minute)
+		if err := store.RecordDecision(ctx, rec); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+
+	recs, err := store.ListDecisions(ctx, "Coder", "Coder", 0, 10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("got %d decisions, want 3", len(recs))
+	}
+	if recs[0].Model != "gpt-4o" || recs[0].TotalTokens != 1200 || recs[0].LatencyMS != 850 {
+		t.Fatalf("got newest %+v, want the gpt-4o decision", recs[0])
+	}
+	if got := recs[0].Reasoning(); got != "It crashes." {
+		t.Fatalf("got reasoning %q, want it from the raw output", got)
+	}
+	if !recs[1].PullRequest || !recs[1].TestMode {
+		t.Fatalf("got %+v, want a test mode pull request", recs[1])
+	}
+	if !recs[2].At.Equal(at) {
+		t.Fatalf("got at %v, want %v", recs[2].At, at)
+	}
+	if !slices.Equal(recs[2].ModelLabels, []string{"bug", "docs"}) ||
+		!slices.Equal(recs[2].Applied, []string{"bug"}) || recs[2].Removed != nil {
+		t.Fatalf("got labels %+v, want them as recorded", recs[2])
+	}
+
+	recs, err = store.ListDecisions(ctx, "coder", "coder", 1, 1)
+	if err != nil {
+		t.Fatalf("list issue: %v", err)
+	}
+	if len(recs) != 1 || recs[0].Issue != 1 || recs[0].Model != "gpt-4o" {
+		t.Fatalf("got %+v, want the newest decision on issue 1", recs)
+	}
+
+	// Decisions survive a restart.
+	store, err = NewSQLiteAuditStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	recs, err = store.ListDecisions(ctx, "coder", "vscode-coder", 0, 10)
+	if err != nil {
+		t.Fatalf("list after reopen: %v", err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("got %d decisions, want 1", len(recs))
+	}
+}
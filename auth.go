@@ -0,0 +1,354 @@
+package labeler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ammario/tlru"
+	"github.com/coder/labeler/httpjson"
+	"github.com/google/go-github/v59/github"
+)
+
+// apiTokenPrefix distinguishes server-issued tokens from GitHub tokens
+// in the Authorization header.
+const apiTokenPrefix = "lbl_"
+
+// apiTokenClaims is the payload of a server-issued API token. Tokens are
+// stateless: the claims are signed with the server's token secret, so
+// nothing needs to be stored to issue or verify them.
+type apiTokenClaims struct {
+	InstallID string `json:"i"`
+	// Repo is "owner/repo". An empty Repo grants access to every repo
+	// in the installation.
+	Repo    string `json:"r,omitempty"`
+	Expires int64  `json:"e,omitempty"`
+	// Operator tokens belong to whoever runs the labeler. They may read
+	// server internals like /debug/caches, but no installation.
+	Operator bool `json:"o,omitempty"`
+}
+
+func signAPIToken(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IssueAPIToken creates a token scoped to an installation, or to a single
+// "owner/repo" within it when repo is non-empty. A zero ttl creates a token
+// that never expires.
+func IssueAPIToken(secret []byte, installID, repo string, ttl time.Duration) (string, error) {
+	if len(secret) == 0 {
+		return "", errors.New("token secret is required")
+	}
+	if installID == "" {
+		return "", errors.New("install ID is required")
+	}
+	return issueAPIToken(secret, apiTokenClaims{
+		InstallID: installID,
+		Repo:      repo,
+	}, ttl)
+}
+
+// IssueOperatorToken creates a token for the labeler's operator, which
+// grants access to server internals but to no installation.
+func IssueOperatorToken(secret []byte, ttl time.Duration) (string, error) {
+	if len(secret) == 0 {
+		return "", errors.New("token secret is required")
+	}
+	return issueAPIToken(secret, apiTokenClaims{Operator: true}, ttl)
+}
+
+func issueAPIToken(secret []byte, claims apiTokenClaims, ttl time.Duration) (string, error) {
+	if ttl > 0 {
+		claims.Expires = time.Now().Add(ttl).Unix()
+	}
+	payloadJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(payloadJSON)
+	return apiTokenPrefix + payload + "." + signAPIToken(secret, payload), nil
+}
+
+func parseAPIToken(secret []byte, token string) (*apiTokenClaims, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("API tokens are not enabled")
+	}
+	payload, sig, ok := strings.Cut(strings.TrimPrefix(token, apiTokenPrefix), ".")
+	if !ok {
+		return nil, errors.New("malformed token")
+	}
+	if !hmac.Equal([]byte(sig), []byte(signAPIToken(secret, payload))) {
+		return nil, errors.New("invalid token signature")
+	}
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode token: %w", err)
+	}
+	var claims apiTokenClaims
+	err = json.Unmarshal(payloadJSON, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal token: %w", err)
+	}
+	if claims.Expires != 0 && time.Now().Unix() > claims.Expires {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+// principal is an authenticated API caller. Exactly one of token or
+// github is set.
+type principal struct {
+	token *apiTokenClaims
+
+	github *github.Client
+	// tokenHash identifies the GitHub token in caches without
+	// keeping the token itself around as a key.
+	tokenHash string
+	login     string
+}
+
+func (p *principal) String() string {
+	if p.token != nil {
+		if p.token.Operator {
+			return "token:operator"
+		}
+		if p.token.Repo != "" {
+			return "token:" + p.token.InstallID + "/" + p.token.Repo
+		}
+		return "token:" + p.token.InstallID
+	}
+	return "github:" + p.login
+}
+
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// authenticator guards HTTP endpoints. Callers present either a
+// server-issued API token or a GitHub user token as a bearer token.
+//
+// It is meant to be used as chi middleware: Authenticate establishes who
+// the caller is, and RequireRepoRead (or canRead, for handlers that
+// authorize against something other than the query string) decides what
+// they may see.
+type authenticator struct {
+	log         *slog.Logger
+	tokenSecret []byte
+	// repoInstallation returns the ID of the app's installation on a
+	// repo, or a GitHub 404 if the app isn't installed on it.
+	repoInstallation func(ctx context.Context, owner, repo string) (int64, error)
+
+	// loginCache maps GitHub token hashes to logins.
+	loginCache *tlru.Cache[string, string]
+	// accessCache maps GitHub token hash and repo to whether the token
+	// can read the repo.
+	accessCache *tlru.Cache[string, bool]
+	// installCache maps repos to the installation on them, or 0.
+	installCache *tlru.Cache[string, int64]
+}
+
+func newAuthenticator(log *slog.Logger, tokenSecret []byte,
+	repoInstallation func(ctx context.Context, owner, repo string) (int64, error),
+) *authenticator {
+	return &authenticator{
+		log:              log,
+		tokenSecret:      tokenSecret,
+		repoInstallation: repoInstallation,
+		loginCache: tlru.New[string](func(string) int {
+			return 1
+		}, 4096),
+		accessCache: tlru.New[string](func(bool) int {
+			return 1
+		}, 4096),
+		installCache: tlru.New[string](func(int64) int {
+			return 1
+		}, 4096),
+	}
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="labeler"`)
+	httpjson.Write(w, http.StatusUnauthorized, httpjson.M{"error": msg})
+}
+
+// Authenticate rejects requests without valid credentials and stores the
+// caller in the request context.
+func (a *authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		token = strings.TrimSpace(token)
+		if !ok || token == "" {
+			unauthorized(w, "missing bearer token")
+			return
+		}
+
+		var p *principal
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			claims, err := parseAPIToken(a.tokenSecret, token)
+			if err != nil {
+				unauthorized(w, err.Error())
+				return
+			}
+			p = &principal{token: claims}
+		} else {
+			hash := sha256.Sum256([]byte(token))
+			p = &principal{
+				github:    github.NewClient(nil).WithAuthToken(token),
+				tokenHash: hex.EncodeToString(hash[:]),
+			}
+			login, err := a.loginCache.Do(p.tokenHash, func() (string, error) {
+				user, _, err := p.github.Users.Get(r.Context(), "")
+				if err != nil {
+					return "", err
+				}
+				return user.GetLogin(), nil
+			}, 5*time.Minute)
+			if err != nil {
+				var githubErr *github.ErrorResponse
+				if errors.As(err, &githubErr) && githubErr.Response.StatusCode == http.StatusUnauthorized {
+					unauthorized(w, "invalid GitHub token")
+					return
+				}
+				httpjson.Write(w, http.StatusBadGateway, httpjson.M{"error": "verify GitHub token: " + err.Error()})
+				return
+			}
+			p.login = login
+		}
+
+		next.ServeHTTP(w, r.WithContext(
+			context.WithValue(r.Context(), principalKey{}, p),
+		))
+	})
+}
+
+// canRead reports whether the caller may read addr. addr.InstallID must
+// also be installed on the repo, or anyone, even with an install-wide API
+// token, could spend an installation's credentials and model budget on
+// public repos it was never installed on.
+func (a *authenticator) canRead(ctx context.Context, p *principal, addr repoAddr) (bool, error) {
+	if p.token != nil {
+		if p.token.InstallID != addr.InstallID ||
+			(p.token.Repo != "" && !strings.EqualFold(p.token.Repo, addr.User+"/"+addr.Repo)) {
+			return false, nil
+		}
+	}
+
+	installed, err := a.installedOn(ctx, addr)
+	if err != nil {
+		return false, fmt.Errorf("find installation: %w", err)
+	}
+	if !installed || p.token != nil {
+		return installed, nil
+	}
+
+	key := p.tokenHash + ":" + strings.ToLower(addr.User+"/"+addr.Repo)
+	return a.accessCache.Do(key, func() (bool, error) {
+		repo, _, err := p.github.Repositories.Get(ctx, addr.User, addr.Repo)
+		if err != nil {
+			var githubErr *github.ErrorResponse
+			if errors.As(err, &githubErr) && githubErr.Response.StatusCode == http.StatusNotFound {
+				return false, nil
+			}
+			return false, err
+		}
+		return repo.GetPermissions()["pull"], nil
+	}, 5*time.Minute)
+}
+
+// RequireOperator only lets operator tokens through. It must run after
+// Authenticate.
+func (a *authenticator) RequireOperator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := principalFromContext(r.Context())
+		if p == nil {
+			unauthorized(w, "not authenticated")
+			return
+		}
+		if p.token == nil || !p.token.Operator {
+			a.log.Warn("forbidden", "principal", p.String(), "path", r.URL.Path)
+			httpjson.Write(w, http.StatusForbidden, httpjson.M{"error": "operator token required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// installedOn reports whether addr.InstallID is the app's installation on
+// addr's repo.
+func (a *authenticator) installedOn(ctx context.Context, addr repoAddr) (bool, error) {
+	key := strings.ToLower(addr.User + "/" + addr.Repo)
+	id, err := a.installCache.Do(key, func() (int64, error) {
+		id, err := a.repoInstallation(ctx, addr.User, addr.Repo)
+		if err != nil {
+			var githubErr *github.ErrorResponse
+			if errors.As(err, &githubErr) && githubErr.Response.StatusCode == http.StatusNotFound {
+				return 0, nil
+			}
+			return 0, err
+		}
+		return id, nil
+	}, 5*time.Minute)
+	if err != nil {
+		return false, err
+	}
+	return id != 0 && strconv.FormatInt(id, 10) == addr.InstallID, nil
+}
+
+// RequireRepoRead authorizes the repo named by the install_id, user and
+// repo query parameters. It must run after Authenticate.
+func (a *authenticator) RequireRepoRead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := principalFromContext(r.Context())
+		if p == nil {
+			unauthorized(w, "not authenticated")
+			return
+		}
+
+		addr := repoAddr{
+			InstallID: r.URL.Query().Get("install_id"),
+			User:      r.URL.Query().Get("user"),
+			Repo:      r.URL.Query().Get("repo"),
+		}
+		if addr.InstallID == "" || addr.User == "" || addr.Repo == "" {
+			httpjson.Write(w, http.StatusBadRequest, httpjson.M{
+				"error": "install_id, user, and repo are required",
+			})
+			return
+		}
+
+		ok, err := a.canRead(r.Context(), p, addr)
+		if err != nil {
+			a.log.Error("check repo access", "error", err, "principal", p.String())
+			httpjson.Write(w, http.StatusBadGateway, httpjson.M{"error": "check repo access: " + err.Error()})
+			return
+		}
+		if !ok {
+			a.log.Warn("forbidden",
+				"principal", p.String(),
+				"repo", addr.User+"/"+addr.Repo,
+				"install_id", addr.InstallID,
+			)
+			httpjson.Write(w, http.StatusForbidden, httpjson.M{
+				"error": fmt.Sprintf("no read access to %s/%s", addr.User, addr.Repo),
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
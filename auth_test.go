@@ -0,0 +1,128 @@
+package labeler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+)
+
+func TestCanReadAPIToken(t *testing.T) {
+	t.Parallel()
+	installations := map[string]int64{"coder/coder": 1, "coder/code-server": 1}
+	a := newAuthenticator(slog.New(slog.NewTextHandler(io.Discard, nil)), []byte("secret"),
+		func(_ context.Context, owner, repo string) (int64, error) {
+			if id, ok := installations[owner+"/"+repo]; ok {
+				return id, nil
+			}
+			return 0, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}
+		},
+	)
+	addr := repoAddr{InstallID: "1", User: "coder", Repo: "coder"}
+
+	for _, tt := range []struct {
+		name   string
+		claims apiTokenClaims
+		addr   repoAddr
+		want   bool
+	}{
+		{"Installation", apiTokenClaims{InstallID: "1"}, addr, true},
+		{"Repo", apiTokenClaims{InstallID: "1", Repo: "Coder/Coder"}, addr, true},
+		{"OtherRepo", apiTokenClaims{InstallID: "1", Repo: "coder/code-server"}, addr, false},
+		{"OtherInstallation", apiTokenClaims{InstallID: "2"}, addr, false},
+		// An install-wide token can't reach repos the app isn't on.
+		{"NotInstalled", apiTokenClaims{InstallID: "1"}, repoAddr{InstallID: "1", User: "golang", Repo: "go"}, false},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := a.canRead(context.Background(), &principal{token: &tt.claims}, tt.addr)
+			if err != nil {
+				t.Fatalf("canRead: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A GitHub token is refused for an installation that isn't on the repo
+// before GitHub is asked whether the token can read it.
+func TestCanReadGitHubOtherInstallation(t *testing.T) {
+	t.Parallel()
+	installations := map[string]int64{"coder/coder": 1}
+	a := newAuthenticator(slog.New(slog.NewTextHandler(io.Discard, nil)), nil,
+		func(_ context.Context, owner, repo string) (int64, error) {
+			if id, ok := installations[owner+"/"+repo]; ok {
+				return id, nil
+			}
+			return 0, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}
+		},
+	)
+	p := &principal{login: "someone", tokenHash: "hash"}
+
+	for _, addr := range []repoAddr{
+		{InstallID: "2", User: "coder", Repo: "coder"},
+		{InstallID: "1", User: "golang", Repo: "go"},
+	} {
+		got, err := a.canRead(context.Background(), p, addr)
+		if err != nil {
+			t.Fatalf("canRead(%v): %v", addr, err)
+		}
+		if got {
+			t.Fatalf("canRead(%v) = true, want false", addr)
+		}
+	}
+
+	a.repoInstallation = func(context.Context, string, string) (int64, error) {
+		return 0, errors.New("boom")
+	}
+	_, err := a.canRead(context.Background(), p, repoAddr{InstallID: "1", User: "coder", Repo: "labeler"})
+	if err == nil {
+		t.Fatal("canRead succeeded despite failing to find the installation")
+	}
+}
+
+func TestRequireOperator(t *testing.T) {
+	t.Parallel()
+	secret := []byte("secret")
+	a := newAuthenticator(slog.New(slog.NewTextHandler(io.Discard, nil)), secret, nil)
+	handler := a.Authenticate(a.RequireOperator(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	operator, err := IssueOperatorToken(secret, 0)
+	if err != nil {
+		t.Fatalf("issue operator token: %v", err)
+	}
+	install, err := IssueAPIToken(secret, "1", "", 0)
+	if err != nil {
+		t.Fatalf("issue API token: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"Operator", operator, http.StatusOK},
+		{"Installation", install, http.StatusForbidden},
+		{"None", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/debug/caches", nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package labeler
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ammario/tlru"
+	"github.com/coder/labeler/httpjson"
+)
+
+// statCache is a tlru.Cache that counts hits, misses and evictions so we
+// can tell whether caching is paying off in production.
+type statCache[K comparable, V any] struct {
+	*tlru.Cache[K, V]
+
+	hits, misses, evictions atomic.Int64
+}
+
+func newStatCache[K comparable, V any](cost func(V) int, maxCost int) *statCache[K, V] {
+	return &statCache[K, V]{
+		Cache: tlru.New[K](cost, maxCost),
+	}
+}
+
+func (c *statCache[K, V]) Do(key K, fn func() (V, error), ttl time.Duration) (V, error) {
+	var miss bool
+	v, err := c.Cache.Do(key, func() (V, error) {
+		miss = true
+		return fn()
+	}, ttl)
+	if miss {
+		c.misses.Add(1)
+	} else {
+		c.hits.Add(1)
+	}
+	return v, err
+}
+
+// Delete evicts key, typically because a webhook told us it's stale.
+func (c *statCache[K, V]) Delete(key K) {
+	c.evictions.Add(1)
+	c.Cache.Delete(key)
+}
+
+type cacheStats struct {
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Evictions int64   `json:"evictions"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+func (c *statCache[K, V]) stats() cacheStats {
+	st := cacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRate = float64(st.Hits) / float64(total)
+	}
+	return st
+}
+
+func (s *Webhook) cacheStats(w http.ResponseWriter, r *http.Request) *httpjson.Response {
+	return &httpjson.Response{
+		Status: http.StatusOK,
+		Body: httpjson.M{
+			"repo_labels":      s.repoLabelsCache.stats(),
+			"recent_issues":    s.recentIssuesCache.stats(),
+			"recent_pulls":     s.recentPullsCache.stats(),
+			"configs":          s.configCache.stats(),
+			"inscriptive":      s.inscriptiveCache.stats(),
+			"query_embeddings": s.queryEmbeddingCache.stats(),
+		},
+	}
+}
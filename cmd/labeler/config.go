@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/coder/labeler"
+	"github.com/coder/labeler/ghapi"
+	"github.com/coder/serpent"
+	"github.com/google/go-github/v59/github"
+)
+
+func (r *rootCmd) configCmd() *serpent.Command {
+	return &serpent.Command{
+		Use:   "config",
+		Short: "Work with .github/labeler.yml configs",
+		Children: []*serpent.Command{
+			r.configLintCmd(),
+		},
+	}
+}
+
+func (r *rootCmd) configLintCmd() *serpent.Command {
+	var (
+		installID string
+		user      string
+		repo      string
+	)
+	return &serpent.Command{
+		Use:   "lint [file]",
+		Short: "Check a config for errors, and its labels against a repo",
+		Long: "Without --install-id, --user and --repo, label references " +
+			"aren't checked.",
+		Handler: func(inv *serpent.Invocation) error {
+			path := ".github/labeler.yml"
+			if len(inv.Args) > 0 {
+				path = inv.Args[0]
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			var labels []string
+			if installID != "" && user != "" && repo != "" {
+				labels, err = r.repoLabels(inv.Context(), installID, user, repo)
+				if err != nil {
+					return err
+				}
+			}
+
+			problems := labeler.LintConfig(content, labels)
+			for _, p := range problems {
+				fmt.Fprintf(inv.Stdout, "%s:%s\n", path, p)
+			}
+			if labeler.LintHasErrors(problems) {
+				return fmt.Errorf("%s is invalid", path)
+			}
+			return nil
+		},
+		Options: []serpent.Option{
+			{
+				Flag:  "install-id",
+				Value: serpent.StringOf(&installID),
+			},
+			{
+				Flag:  "user",
+				Value: serpent.StringOf(&user),
+			},
+			{
+				Flag:  "repo",
+				Value: serpent.StringOf(&repo),
+			},
+		},
+	}
+}
+
+func (r *rootCmd) repoLabels(ctx context.Context, installID, user, repo string) ([]string, error) {
+	appConfig, err := r.appConfig()
+	if err != nil {
+		return nil, err
+	}
+	instConfig, err := appConfig.InstallationConfig(installID)
+	if err != nil {
+		return nil, fmt.Errorf("get installation config: %w", err)
+	}
+	githubClient := github.NewClient(instConfig.Client(ctx))
+
+	labels, err := ghapi.Page(
+		ctx,
+		githubClient,
+		func(ctx context.Context, opt *github.ListOptions) ([]*github.Label, *github.Response, error) {
+			return githubClient.Issues.ListLabels(ctx, user, repo, opt)
+		},
+		-1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list labels: %w", err)
+	}
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		names = append(names, label.GetName())
+	}
+	return names, nil
+}
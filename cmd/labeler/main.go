@@ -16,6 +16,8 @@ import (
 	"github.com/beatlabs/github-auth/app"
 	appkey "github.com/beatlabs/github-auth/key"
 	"github.com/coder/labeler"
+	"github.com/coder/labeler/llm"
+	"github.com/coder/labeler/queue"
 	"github.com/coder/retry"
 	"github.com/coder/serpent"
 	"github.com/go-chi/chi/v5"
@@ -51,12 +53,43 @@ type rootCmd struct {
 	appPEMEnv       string
 	appID           string
 	openAIKey       string
+	llmModel        string
 	openAIModel     string
+	anthropicKey    string
 	bindAddr        string
 	googleProjectID string
 	indexInterval   time.Duration
+
+	llmProvider       string
+	llmBaseURL        string
+	embeddingProvider string
+	embeddingBaseURL  string
+	embeddingModel    string
+
+	webhookSecret         string
+	webhookSecretPrevious string
+	apiTokenSecret        string
+
+	oauthClientID     string
+	oauthClientSecret string
+
+	queueDir     string
+	queueWorkers int64
+	feedbackDir  string
+
+	auditDir      string
+	auditBigQuery bool
+
+	issueStore    string
+	issueStoreDir string
 }
 
+// Values of --issue-store.
+const (
+	issueStoreBigQuery = "bigquery"
+	issueStoreSQLite   = "sqlite"
+)
+
 func (r *rootCmd) appConfig() (*app.Config, error) {
 	var (
 		err    error
@@ -82,22 +115,61 @@ func (r *rootCmd) appConfig() (*app.Config, error) {
 	return appConfig, nil
 }
 
-func (r *rootCmd) ai(ctx context.Context) (*openai.Client, error) {
-	openAIKey := strings.TrimSpace(r.openAIKey)
-
-	if openAIKey == "" {
-		return nil, fmt.Errorf("openai-key is required")
+func (r *rootCmd) llmConfig(provider, baseURL string) llm.Config {
+	key := r.openAIKey
+	if provider == llm.ProviderAnthropic {
+		key = r.anthropicKey
 	}
+	return llm.Config{
+		Provider: provider,
+		APIKey:   strings.TrimSpace(key),
+		BaseURL:  baseURL,
+	}
+}
 
-	oai := openai.NewClient(openAIKey)
+// model returns the chat model. Only openai has a default, since the other
+// providers name their models or deployments differently.
+func (r *rootCmd) model() (string, error) {
+	switch {
+	case r.llmModel != "":
+		return r.llmModel, nil
+	case r.openAIModel != "":
+		return r.openAIModel, nil
+	case r.llmProvider == llm.ProviderOpenAI:
+		return openai.GPT4oMini, nil
+	}
+	return "", fmt.Errorf("--llm-model is required with --llm-provider %s", r.llmProvider)
+}
 
-	// Validate the OpenAI API key.
-	_, err := oai.ListModels(ctx)
+// ai returns the providers of chat completions and of embeddings. embed
+// is nil if the chat provider has no embeddings and no other embedding
+// provider is set.
+func (r *rootCmd) ai(ctx context.Context) (chat, embed llm.Provider, err error) {
+	chat, err = llm.New(r.llmConfig(r.llmProvider, r.llmBaseURL))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Validate the credentials.
+	err = chat.Check(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("list models: %w", err)
+		return nil, nil, fmt.Errorf("%s: %w", r.llmProvider, err)
 	}
 
-	return openai.NewClient(openAIKey), nil
+	if r.embeddingProvider == "" {
+		if r.llmProvider == llm.ProviderAnthropic {
+			return chat, nil, nil
+		}
+		return chat, chat, nil
+	}
+	embed, err = llm.New(r.llmConfig(r.embeddingProvider, r.embeddingBaseURL))
+	if err != nil {
+		return nil, nil, fmt.Errorf("embeddings: %w", err)
+	}
+	err = embed.Check(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", r.embeddingProvider, err)
+	}
+	return chat, embed, nil
 }
 
 func main() {
@@ -108,6 +180,8 @@ func main() {
 		Short: "labeler is the GitHub labeler backend service",
 		Children: []*serpent.Command{
 			root.testCmd(),
+			root.tokenCmd(),
+			root.configCmd(),
 		},
 		Handler: func(inv *serpent.Invocation) error {
 			log.Debug("starting labeler")
@@ -118,9 +192,13 @@ func main() {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			oai, err := root.ai(ctx)
+			model, err := root.model()
+			if err != nil {
+				return err
+			}
+			chat, embed, err := root.ai(ctx)
 			if err != nil {
-				return fmt.Errorf("openai: %w", err)
+				return fmt.Errorf("llm: %w", err)
 			}
 
 			appConfig, err := root.appConfig()
@@ -146,35 +224,112 @@ func main() {
 				listener.Close()
 			}()
 
+			if root.webhookSecret == "" {
+				return fmt.Errorf("WEBHOOK_SECRET is required")
+			}
+
 			wh := &labeler.Webhook{
 				Log:       log,
-				OpenAI:    oai,
-				Model:     root.openAIModel,
+				LLM:       chat,
+				Model:     model,
 				AppConfig: appConfig,
+				WebhookSecrets: []string{
+					root.webhookSecret,
+					root.webhookSecretPrevious,
+				},
+				APITokenSecret: root.apiTokenSecret,
+
+				OAuthClientID:     root.oauthClientID,
+				OAuthClientSecret: root.oauthClientSecret,
+			}
+			if root.oauthClientID != "" && root.oauthClientSecret == "" {
+				return fmt.Errorf("OAUTH_CLIENT_SECRET is required with --oauth-client-id")
+			}
+
+			if root.queueDir != "" {
+				wh.Queue, err = queue.Open(log, root.queueDir)
+				if err != nil {
+					return fmt.Errorf("open queue: %w", err)
+				}
+			}
+
+			if root.feedbackDir != "" {
+				wh.Feedback, err = labeler.NewSQLiteFeedbackStore(root.feedbackDir)
+				if err != nil {
+					return fmt.Errorf("open feedback store: %w", err)
+				}
+			}
+
+			// BigQuery is only needed by the stores that use it, so
+			// self-hosters can run without GCP.
+			var bqClient *bigquery.Client
+			if root.issueStore == issueStoreBigQuery || root.auditBigQuery {
+				bqClient, err = bigquery.NewClient(ctx, root.googleProjectID)
+				if err != nil {
+					return fmt.Errorf("bigquery: %w", err)
+				}
+				defer bqClient.Close()
+			}
+
+			var issues labeler.IssueStore
+			switch root.issueStore {
+			case issueStoreBigQuery:
+				issues = labeler.NewBigQueryIssueStore(bqClient)
+			case issueStoreSQLite:
+				issues, err = labeler.NewSQLiteIssueStore(root.issueStoreDir)
+				if err != nil {
+					return fmt.Errorf("open issue store: %w", err)
+				}
+			}
+			wh.Issues = issues
+			wh.Embeddings = embed
+			wh.EmbeddingModel = root.embeddingModel
+
+			switch {
+			case root.auditBigQuery:
+				wh.Audit = labeler.NewBigQueryAuditStore(bqClient)
+			case root.auditDir != "":
+				wh.Audit, err = labeler.NewSQLiteAuditStore(root.auditDir)
+				if err != nil {
+					return fmt.Errorf("open audit store: %w", err)
+				}
 			}
 
 			mux := chi.NewMux()
 
 			wh.Init(mux)
 
-			bqClient, err := bigquery.NewClient(ctx, root.googleProjectID)
-			if err != nil {
-				return fmt.Errorf("bigquery: %w", err)
+			// Only now is the webhook fully set up for jobs left over from
+			// the last run.
+			if wh.Queue != nil {
+				go func() {
+					err := wh.RunWorkers(ctx, int(root.queueWorkers))
+					if err != nil && ctx.Err() == nil {
+						log.Error("queue workers", "err", err)
+					}
+				}()
 			}
-			defer bqClient.Close()
 
 			idx := &labeler.Indexer{
 				Log:           log,
-				OpenAI:        oai,
 				AppConfig:     appConfig,
-				BigQuery:      bqClient,
+				Issues:        issues,
 				IndexInterval: root.indexInterval,
+
+				LLM:            embed,
+				EmbeddingModel: root.embeddingModel,
 			}
 
 			go func() {
 				if root.indexInterval == 0 {
 					return
 				}
+				if embed == nil {
+					log.Warn("indexer disabled, no embedding provider",
+						"llm_provider", root.llmProvider,
+					)
+					return
+				}
 				ret := retry.New(time.Second, time.Minute)
 
 			retry:
@@ -210,24 +365,94 @@ func main() {
 				Default:     "localhost:8080",
 				Value:       serpent.StringOf(&root.bindAddr),
 			},
+			{
+				Flag: "oauth-client-id",
+				Description: "GitHub App OAuth client ID, used to sign in to " +
+					"the dashboard. If empty, the dashboard is disabled.",
+				Value: serpent.StringOf(&root.oauthClientID),
+			},
+			{
+				Flag: "llm-model",
+				Description: "Chat model to use, as named by --llm-provider. " +
+					"Defaults to " + openai.GPT4oMini + " for openai, and is " +
+					"required for the other providers.",
+				Value: serpent.StringOf(&root.llmModel),
+			},
 			{
 				Flag:        "openai-model",
-				Default:     openai.GPT4oMini,
-				Description: "OpenAI model to use.",
+				Description: "Deprecated alias of --llm-model.",
 				Value:       serpent.StringOf(&root.openAIModel),
+				UseInstead:  []serpent.Option{{Flag: "llm-model"}},
+			},
+			{
+				Flag: "llm-provider",
+				Description: "Provider of chat completions: " +
+					strings.Join(llm.Providers, ", ") + ".",
+				Value:   serpent.EnumOf(&root.llmProvider, llm.Providers...),
+				Default: llm.ProviderOpenAI,
+			},
+			{
+				Flag: "llm-base-url",
+				Description: "Endpoint of the chat completion provider. Required " +
+					"for azure, e.g. https://<resource>.openai.azure.com, and for " +
+					"openai-compatible, e.g. http://localhost:11434/v1 for Ollama.",
+				Value: serpent.StringOf(&root.llmBaseURL),
+			},
+			{
+				Flag: "embedding-provider",
+				Description: "Provider of embeddings, if not the chat completion " +
+					"provider. anthropic has no embeddings, so the indexer is " +
+					"disabled unless this is set.",
+				Value: serpent.StringOf(&root.embeddingProvider),
+			},
+			{
+				Flag:        "embedding-base-url",
+				Description: "Endpoint of --embedding-provider.",
+				Value:       serpent.StringOf(&root.embeddingBaseURL),
+			},
+			{
+				Flag:        "embedding-model",
+				Description: "Embedding model to use.",
+				Value:       serpent.StringOf(&root.embeddingModel),
+				Default:     string(openai.SmallEmbedding3),
 			},
 			// SECRETS: only configurable via environment variables.
 			{
-				Description: "OpenAI API key.",
+				Description: "API key for openai, azure and openai-compatible providers.",
 				Env:         "OPENAI_API_KEY",
-				Required:    true,
 				Value:       serpent.StringOf(&root.openAIKey),
 			},
+			{
+				Description: "API key for the anthropic provider.",
+				Env:         "ANTHROPIC_API_KEY",
+				Value:       serpent.StringOf(&root.anthropicKey),
+			},
 			{
 				Env:         "GITHUB_APP_PEM",
 				Description: "APP PEM in raw form.",
 				Value:       serpent.StringOf(&root.appPEMEnv),
 			},
+			{
+				Env:         "WEBHOOK_SECRET",
+				Description: "Secret used to verify GitHub webhook signatures.",
+				Value:       serpent.StringOf(&root.webhookSecret),
+			},
+			{
+				Env: "WEBHOOK_SECRET_PREVIOUS",
+				Description: "Previous webhook secret, still accepted while " +
+					"rotating to a new WEBHOOK_SECRET.",
+				Value: serpent.StringOf(&root.webhookSecretPrevious),
+			},
+			{
+				Env:         "API_TOKEN_SECRET",
+				Description: "Secret used to sign and verify API tokens.",
+				Value:       serpent.StringOf(&root.apiTokenSecret),
+			},
+			{
+				Env:         "OAUTH_CLIENT_SECRET",
+				Description: "GitHub App OAuth client secret.",
+				Value:       serpent.StringOf(&root.oauthClientSecret),
+			},
 			{
 				Flag:    "google-project-id",
 				Env:     "GOOGLE_PROJECT_ID",
@@ -240,6 +465,51 @@ func main() {
 				Value:       serpent.DurationOf(&root.indexInterval),
 				Default:     "1h",
 			},
+			{
+				Flag: "queue-dir",
+				Description: "Directory of the durable webhook job queue. " +
+					"It must be on a persistent volume for jobs to survive restarts. " +
+					"If empty, webhooks are processed within the request.",
+				Value: serpent.StringOf(&root.queueDir),
+			},
+			{
+				Flag:        "queue-workers",
+				Description: "Number of workers processing the webhook job queue.",
+				Value:       serpent.Int64Of(&root.queueWorkers),
+				Default:     "4",
+			},
+			{
+				Flag: "feedback-dir",
+				Description: "Directory of the SQLite database recording applied " +
+					"labels and human corrections. It must be on a persistent volume. " +
+					"If empty, feedback is not recorded.",
+				Value: serpent.StringOf(&root.feedbackDir),
+			},
+			{
+				Flag: "audit-dir",
+				Description: "Directory of the SQLite database recording every labeling decision. " +
+					"It must be on a persistent volume. If empty, and --audit-bigquery " +
+					"isn't set, decisions are not recorded.",
+				Value: serpent.StringOf(&root.auditDir),
+			},
+			{
+				Flag: "issue-store",
+				Description: "Where the indexer keeps embedded issues: bigquery, " +
+					"or sqlite for a local store in --issue-store-dir.",
+				Value:   serpent.EnumOf(&root.issueStore, issueStoreBigQuery, issueStoreSQLite),
+				Default: issueStoreBigQuery,
+			},
+			{
+				Flag:        "issue-store-dir",
+				Description: "Directory of the local issue store.",
+				Value:       serpent.StringOf(&root.issueStoreDir),
+				Default:     "./issue-data",
+			},
+			{
+				Flag:        "audit-bigquery",
+				Description: "Record labeling decisions in BigQuery instead of --audit-dir.",
+				Value:       serpent.BoolOf(&root.auditBigQuery),
+			},
 		},
 	}
 
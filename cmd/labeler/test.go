@@ -132,15 +132,19 @@ func (r *rootCmd) testCmd() *serpent.Command {
 
 			ctx := inv.Context()
 
-			ai, err := r.ai(ctx)
+			model, err := r.model()
+			if err != nil {
+				return err
+			}
+			ai, _, err := r.ai(ctx)
 			if err != nil {
 				return err
 			}
 
 			srv := &labeler.Webhook{
 				Log:       log,
-				OpenAI:    ai,
-				Model:     r.openAIModel,
+				LLM:       ai,
+				Model:     model,
 				AppConfig: appConfig,
 			}
 			mux := chi.NewMux()
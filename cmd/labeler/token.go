@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coder/labeler"
+	"github.com/coder/serpent"
+)
+
+func (r *rootCmd) tokenCmd() *serpent.Command {
+	var (
+		installID string
+		repo      string
+		ttl       time.Duration
+		operator  bool
+	)
+	return &serpent.Command{
+		Use:   "token",
+		Short: "Issue an API token scoped to an installation or repo, or for the operator",
+		Handler: func(inv *serpent.Invocation) error {
+			if r.apiTokenSecret == "" {
+				return fmt.Errorf("API_TOKEN_SECRET is required")
+			}
+
+			var (
+				token string
+				err   error
+			)
+			switch {
+			case operator && (installID != "" || repo != ""):
+				return fmt.Errorf("--operator can't be combined with --install-id or --repo")
+			case operator:
+				token, err = labeler.IssueOperatorToken([]byte(r.apiTokenSecret), ttl)
+			default:
+				token, err = labeler.IssueAPIToken(
+					[]byte(r.apiTokenSecret), installID, repo, ttl,
+				)
+			}
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintln(inv.Stdout, token)
+			return err
+		},
+		Options: []serpent.Option{
+			{
+				Flag:        "install-id",
+				Description: "Installation the token is scoped to. Required unless --operator is set.",
+				Value:       serpent.StringOf(&installID),
+			},
+			{
+				Flag:        "repo",
+				Description: "Restrict the token to a single owner/repo.",
+				Value:       serpent.StringOf(&repo),
+			},
+			{
+				Flag:        "operator",
+				Description: "Issue an operator token, for server internals like /debug/caches.",
+				Value:       serpent.BoolOf(&operator),
+			},
+			{
+				Flag:        "ttl",
+				Description: "Lifetime of the token. Zero means no expiry.",
+				Value:       serpent.DurationOf(&ttl),
+				Default:     "720h",
+			},
+		},
+	}
+}
@@ -0,0 +1,387 @@
+package labeler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/coder/labeler/ghapi"
+	"github.com/coder/labeler/httpjson"
+	githook "github.com/go-playground/webhooks/v6/github"
+	"github.com/google/go-github/v59/github"
+)
+
+// commandPrefix starts a slash command in an issue comment, e.g.
+// "/labeler relabel".
+const commandPrefix = "/labeler"
+
+const commandUsage = "Usage: `/labeler relabel`, `/labeler explain`, `/labeler undo` or `/labeler not-duplicate`."
+
+const jobKindCommand = "command"
+
+// commandJob runs a slash command from an issue comment.
+type commandJob struct {
+	InstallID string `json:"install_id"`
+	User      string `json:"user"`
+	Repo      string `json:"repo"`
+	Issue     int    `json:"issue"`
+	URL       string `json:"url"`
+
+	CommentID int64  `json:"comment_id"`
+	Commenter string `json:"commenter"`
+	Command   string `json:"command"`
+
+	PullRequest bool `json:"pull_request,omitempty"`
+}
+
+// parseCommand returns the first slash command in a comment body.
+func parseCommand(body string) (string, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != commandPrefix {
+			continue
+		}
+		if len(fields) == 1 {
+			return "", true
+		}
+		return strings.ToLower(fields[1]), true
+	}
+	return "", false
+}
+
+func (s *Webhook) issueCommentEvent(r *http.Request, payload githook.IssueCommentPayload) *httpjson.Response {
+	if payload.Action != "created" {
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"message": "not a new comment"},
+		}
+	}
+	// Never respond to bots, including ourselves.
+	if payload.Sender.Type == "Bot" {
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"message": "ignoring bot comment"},
+		}
+	}
+
+	command, ok := parseCommand(payload.Comment.Body)
+	if !ok {
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"message": "no command"},
+		}
+	}
+
+	repo := payload.Repository
+	return s.dispatch(r, jobKindCommand, commandJob{
+		InstallID: strconv.FormatInt(payload.Installation.ID, 10),
+		User:      repo.Owner.Login,
+		Repo:      repo.Name,
+		Issue:     int(payload.Issue.Number),
+		URL:       payload.Issue.HTMLURL,
+		CommentID: payload.Comment.ID,
+		Commenter: payload.Sender.Login,
+		Command:   command,
+
+		PullRequest: payload.Issue.PullRequest != nil,
+	})
+}
+
+// botLogin returns the login the app acts as, e.g. "coder-labeler[bot]".
+func (s *Webhook) botLogin(ctx context.Context) (string, error) {
+	s.botLoginMu.Lock()
+	defer s.botLoginMu.Unlock()
+	if s.botLoginValue != "" {
+		return s.botLoginValue, nil
+	}
+
+	app, _, err := github.NewClient(s.AppConfig.Client()).Apps.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("get app: %w", err)
+	}
+	s.botLoginValue = app.GetSlug() + "[bot]"
+	return s.botLoginValue, nil
+}
+
+// botLabels returns the labels on the issue that were last added by the
+// bot, in the order they were added.
+func (s *Webhook) botLabels(ctx context.Context, client *github.Client,
+	owner, repo string, number int,
+) ([]string, error) {
+	bot, err := s.botLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := listIssueEvents(ctx, client, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return replayBotLabels(events, bot), nil
+}
+
+// removedLabels returns the labels someone other than the bot took off
+// the issue and nobody has put back.
+func (s *Webhook) removedLabels(ctx context.Context, client *github.Client,
+	owner, repo string, number int,
+) ([]string, error) {
+	bot, err := s.botLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events, err := listIssueEvents(ctx, client, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return replayRemovedLabels(events, bot), nil
+}
+
+// listIssueEvents returns all of an issue's events, oldest first.
+func listIssueEvents(ctx context.Context, client *github.Client,
+	owner, repo string, number int,
+) ([]*github.IssueEvent, error) {
+	events, err := ghapi.Page(
+		ctx,
+		client,
+		func(ctx context.Context, opt *github.ListOptions) ([]*github.IssueEvent, *github.Response, error) {
+			return client.Issues.ListIssueEvents(ctx, owner, repo, number, opt)
+		},
+		-1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list issue events: %w", err)
+	}
+	return events, nil
+}
+
+// replayBotLabels replays an issue's events so a label the bot added, a
+// human removed and then re-added isn't attributed to the bot. It returns
+// the labels the bot last added, each once, in the order they were added.
+func replayBotLabels(events []*github.IssueEvent, bot string) []string {
+	var (
+		labels []string
+		byBot  = make(map[string]bool)
+	)
+	for _, ev := range events {
+		name := ev.GetLabel().GetName()
+		switch ev.GetEvent() {
+		case "labeled":
+			labels = filterSlice(labels, func(label string) bool {
+				return label != name
+			})
+			labels = append(labels, name)
+			byBot[name] = ev.GetActor().GetLogin() == bot
+		case "unlabeled":
+			delete(byBot, name)
+		}
+	}
+	return filterSlice(labels, func(label string) bool {
+		return byBot[label]
+	})
+}
+
+// replayRemovedLabels replays an issue's events and returns the labels
+// whose last event is their removal by someone other than the bot, in the
+// order they were removed.
+func replayRemovedLabels(events []*github.IssueEvent, bot string) []string {
+	var removed []string
+	for _, ev := range events {
+		name := ev.GetLabel().GetName()
+		switch ev.GetEvent() {
+		case "labeled", "unlabeled":
+			removed = filterSlice(removed, func(label string) bool {
+				return label != name
+			})
+			if ev.GetEvent() == "unlabeled" && ev.GetActor().GetLogin() != bot {
+				removed = append(removed, name)
+			}
+		}
+	}
+	return removed
+}
+
+func (s *Webhook) runCommand(ctx context.Context, job commandJob) (httpjson.M, error) {
+	instConfig, err := s.AppConfig.InstallationConfig(job.InstallID)
+	if err != nil {
+		return nil, err
+	}
+	client := github.NewClient(instConfig.Client(ctx))
+
+	log := s.Log.With(
+		"repo", job.User+"/"+job.Repo,
+		"issue", job.Issue,
+		"commenter", job.Commenter,
+		"command", job.Command,
+	)
+
+	react := func(content string) {
+		_, _, err := client.Reactions.CreateIssueCommentReaction(
+			ctx, job.User, job.Repo, job.CommentID, content,
+		)
+		if err != nil {
+			log.Warn("react to comment", "error", err, "reaction", content)
+		}
+	}
+	comment := func(body string) error {
+		_, _, err := client.Issues.CreateComment(ctx, job.User, job.Repo, job.Issue, &github.IssueComment{
+			Body: &body,
+		})
+		return err
+	}
+
+	perm, _, err := client.Repositories.GetPermissionLevel(ctx, job.User, job.Repo, job.Commenter)
+	if err != nil {
+		return nil, fmt.Errorf("get permission level: %w", err)
+	}
+	if p := perm.GetPermission(); p != "admin" && p != "write" {
+		log.Info("command denied", "permission", p)
+		react("-1")
+		return httpjson.M{"message": "commenter lacks write permission"}, nil
+	}
+
+	react("eyes")
+
+	switch job.Command {
+	case "relabel":
+		result, err := s.labelIssue(ctx, labelJob{
+			InstallID: job.InstallID,
+			User:      job.User,
+			Repo:      job.Repo,
+			Issue:     job.Issue,
+			URL:       job.URL,
+
+			PullRequest: job.PullRequest,
+		})
+		if err != nil {
+			return nil, err
+		}
+		react("rocket")
+		return result, nil
+	case "explain":
+		if job.PullRequest {
+			config, err := s.getRepoConfig(ctx, client, job.User, job.Repo)
+			if err != nil {
+				return nil, fmt.Errorf("get repo config: %w", err)
+			}
+			if !config.PullRequests.Enabled {
+				react("confused")
+				err = comment("Pull request labeling is disabled in this repo, so I wouldn't set any labels. " +
+					"Set `pull_requests.enabled` in `.github/labeler.yml` to turn it on.")
+				if err != nil {
+					return nil, fmt.Errorf("comment: %w", err)
+				}
+				return httpjson.M{"message": "pull request labeling is disabled"}, nil
+			}
+		}
+		resp, err := s.Infer(ctx, &InferRequest{
+			InstallID: job.InstallID,
+			User:      job.User,
+			Repo:      job.Repo,
+			Issue:     job.Issue,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("infer: %w", err)
+		}
+		err = comment(explainComment(resp))
+		if err != nil {
+			return nil, fmt.Errorf("comment: %w", err)
+		}
+		return httpjson.M{"message": "explained", "labels": resp.SetLabels}, nil
+	case "undo":
+		labels, err := s.botLabels(ctx, client, job.User, job.Repo, job.Issue)
+		if err != nil {
+			return nil, err
+		}
+		for _, label := range labels {
+			_, err := client.Issues.RemoveLabelForIssue(ctx, job.User, job.Repo, job.Issue, label)
+			if err != nil {
+				var githubErr *github.ErrorResponse
+				if errors.As(err, &githubErr) && githubErr.Response.StatusCode == http.StatusNotFound {
+					// Already removed.
+					continue
+				}
+				return nil, fmt.Errorf("remove %q: %w", label, err)
+			}
+		}
+		log.Info("undid labels", "labels", labels)
+		react("rocket")
+		return httpjson.M{"message": "labels removed", "labels": labels}, nil
+	case "not-duplicate":
+		rejected, err := s.rejectDuplicates(ctx, client, job)
+		if err != nil {
+			return nil, err
+		}
+		if len(rejected) == 0 {
+			react("confused")
+			err = comment("I haven't suggested any duplicates of this issue.")
+			if err != nil {
+				return nil, fmt.Errorf("comment: %w", err)
+			}
+			return httpjson.M{"message": "no duplicates suggested"}, nil
+		}
+		log.Info("rejected duplicates", "duplicates", rejected)
+		react("rocket")
+		return httpjson.M{"message": "duplicates rejected"}, nil
+	default:
+		react("confused")
+		msg := commandUsage
+		if job.Command != "" {
+			msg = fmt.Sprintf("Unknown command %q. %s", job.Command, commandUsage)
+		}
+		err = comment(msg)
+		if err != nil {
+			return nil, fmt.Errorf("comment: %w", err)
+		}
+		return httpjson.M{"message": "unknown command"}, nil
+	}
+}
+
+func explainComment(resp *InferResponse) string {
+	var sb strings.Builder
+	if len(resp.SetLabels) == 0 {
+		sb.WriteString("I wouldn't set any labels on this issue.\n")
+		if resp.Reasoning != "" {
+			sb.WriteString("\n")
+			for _, line := range strings.Split(resp.Reasoning, "\n") {
+				sb.WriteString("> " + line + "\n")
+			}
+		}
+		return sb.String()
+	}
+
+	sb.WriteString("I would set these labels:\n\n")
+	for _, label := range resp.SetLabels {
+		fmt.Fprintf(&sb, "- `%s`", label)
+		if reason := labelReason(resp, label); reason != "" {
+			sb.WriteString(": " + strings.Join(strings.Fields(reason), " "))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// labelReason explains why resp sets label: the repo's rules, or the
+// model's reason for it.
+func labelReason(resp *InferResponse, label string) string {
+	for _, rl := range resp.RuleLabels {
+		if rl.Label == label {
+			return fmt.Sprintf("set by the repo's rule %q.", rl.Rule)
+		}
+	}
+	for _, f := range resp.RuleFirings {
+		if f.Label != label || f.Action != ruleActionAdded {
+			continue
+		}
+		because := "`" + strings.Join(f.Because, "`, `") + "`"
+		switch f.Rule {
+		case "implies":
+			return "implied by " + because + "."
+		case "requires_one_of":
+			return "picked because the repo requires one of " + because + "."
+		}
+	}
+	return resp.LabelReasons[label]
+}
@@ -0,0 +1,231 @@
+package labeler
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v59/github"
+)
+
+func TestParseCommand(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name    string
+		body    string
+		want    string
+		wantCmd bool
+	}{
+		{"None", "Thanks for the report!", "", false},
+		{"Relabel", "/labeler relabel", "relabel", true},
+		{"CaseInsensitive", "/labeler Explain please", "explain", true},
+		{"Bare", "/labeler", "", true},
+		{"LaterLine", "Looks wrong.\n\n  /labeler undo\n", "undo", true},
+		{"FirstOnly", "/labeler undo\n/labeler relabel", "undo", true},
+		{"NotAtStart", "try /labeler relabel", "", false},
+		{"OtherPrefix", "/labelers relabel", "", false},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := parseCommand(tt.body)
+			if got != tt.want || ok != tt.wantCmd {
+				t.Fatalf("parseCommand(%q) = %q, %v, want %q, %v", tt.body, got, ok, tt.want, tt.wantCmd)
+			}
+		})
+	}
+}
+
+func TestReplayBotLabels(t *testing.T) {
+	t.Parallel()
+	const bot = "coder-labeler[bot]"
+	ev := func(event, label, actor string) *github.IssueEvent {
+		return &github.IssueEvent{
+			Event: github.String(event),
+			Label: &github.Label{Name: github.String(label)},
+			Actor: &github.User{Login: github.String(actor)},
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		events []*github.IssueEvent
+		want   []string
+	}{
+		{
+			name: "AddedByBot",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+				ev("labeled", "area/ui", bot),
+			},
+			want: []string{"bug", "area/ui"},
+		},
+		{
+			name: "AddedByHuman",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+				ev("labeled", "p1", "maintainer"),
+			},
+			want: []string{"bug"},
+		},
+		{
+			name: "RemovedByHuman",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+				ev("unlabeled", "bug", "maintainer"),
+			},
+		},
+		{
+			name: "ReaddedByHuman",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+				ev("unlabeled", "bug", "maintainer"),
+				ev("labeled", "bug", "maintainer"),
+			},
+		},
+		{
+			name: "ReaddedByBot",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+				ev("labeled", "docs", bot),
+				ev("unlabeled", "bug", "maintainer"),
+				ev("labeled", "bug", bot),
+			},
+			want: []string{"docs", "bug"},
+		},
+		{
+			name: "OtherEvents",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+				{Event: github.String("closed"), Actor: &github.User{Login: github.String("maintainer")}},
+			},
+			want: []string{"bug"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := replayBotLabels(tt.events, bot)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayRemovedLabels(t *testing.T) {
+	t.Parallel()
+	const bot = "coder-labeler[bot]"
+	ev := func(event, label, actor string) *github.IssueEvent {
+		return &github.IssueEvent{
+			Event: github.String(event),
+			Label: &github.Label{Name: github.String(label)},
+			Actor: &github.User{Login: github.String(actor)},
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		events []*github.IssueEvent
+		want   []string
+	}{
+		{
+			name: "Untouched",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+			},
+		},
+		{
+			name: "RemovedByHuman",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+				ev("labeled", "docs", bot),
+				ev("unlabeled", "docs", "maintainer"),
+				ev("unlabeled", "bug", "maintainer"),
+			},
+			want: []string{"docs", "bug"},
+		},
+		{
+			name: "RemovedByBot",
+			events: []*github.IssueEvent{
+				ev("labeled", "s2", "maintainer"),
+				ev("unlabeled", "s2", bot),
+			},
+		},
+		{
+			name: "PutBack",
+			events: []*github.IssueEvent{
+				ev("labeled", "bug", bot),
+				ev("unlabeled", "bug", "maintainer"),
+				ev("labeled", "bug", "other-maintainer"),
+			},
+		},
+		{
+			name: "RemovedAgain",
+			events: []*github.IssueEvent{
+				ev("unlabeled", "bug", "maintainer"),
+				ev("labeled", "bug", bot),
+				ev("unlabeled", "bug", "maintainer"),
+			},
+			want: []string{"bug"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := replayRemovedLabels(tt.events, bot)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplainComment(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name string
+		resp InferResponse
+		want string
+	}{
+		{
+			name: "NoLabels",
+			resp: InferResponse{Reasoning: "It's a question.\nNothing applies."},
+			want: "I wouldn't set any labels on this issue.\n\n> It's a question.\n> Nothing applies.\n",
+		},
+		{
+			name: "PerLabel",
+			resp: InferResponse{
+				SetLabels: []string{"bug", "area/ui", "needs-triage", "p2", "s3"},
+				Reasoning: "One blob for everything.",
+				LabelReasons: map[string]string{
+					"bug":     "The app\ncrashes on start.",
+					"area/ui": "The crash is in the settings page.",
+				},
+				RuleLabels: []ruleLabel{{Label: "needs-triage", Rule: "new issues"}},
+				RuleFirings: []ruleFiring{
+					{Rule: "implies", Label: "p2", Action: ruleActionAdded, Because: []string{"bug"}},
+					{Rule: "requires_one_of", Label: "s3", Action: ruleActionAdded, Because: []string{"s2", "s3"}},
+				},
+			},
+			want: "I would set these labels:\n\n" +
+				"- `bug`: The app crashes on start.\n" +
+				"- `area/ui`: The crash is in the settings page.\n" +
+				"- `needs-triage`: set by the repo's rule \"new issues\".\n" +
+				"- `p2`: implied by `bug`.\n" +
+				"- `s3`: picked because the repo requires one of `s2`, `s3`.\n",
+		},
+		{
+			name: "NoReason",
+			resp: InferResponse{SetLabels: []string{"bug"}},
+			want: "I would set these labels:\n\n- `bug`\n",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := explainComment(&tt.resp); got != tt.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package labeler
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// labelConfidences derives a confidence for each label in the structured
+// output from the token log probabilities. A label's confidence is the
+// joint probability of the tokens that spell it out.
+//
+// It returns nil if the log probabilities don't line up with the content,
+// e.g. because the provider didn't return them.
+func labelConfidences(content string, logProbs []openai.LogProb) map[string]float64 {
+	if len(logProbs) == 0 {
+		return nil
+	}
+
+	// Rebuild the content from the tokens, remembering where each one
+	// starts.
+	var (
+		rebuilt bytes.Buffer
+		starts  = make([]int, len(logProbs))
+	)
+	for i, lp := range logProbs {
+		starts[i] = rebuilt.Len()
+		if len(lp.Bytes) > 0 {
+			rebuilt.Write(lp.Bytes)
+		} else {
+			rebuilt.WriteString(lp.Token)
+		}
+	}
+	if rebuilt.String() != content {
+		return nil
+	}
+
+	// spanLogProb sums the log probabilities of the tokens overlapping
+	// content[start:end].
+	spanLogProb := func(start, end int) float64 {
+		var sum float64
+		for i, lp := range logProbs {
+			tokEnd := rebuilt.Len()
+			if i+1 < len(starts) {
+				tokEnd = starts[i+1]
+			}
+			if tokEnd <= start || starts[i] >= end {
+				continue
+			}
+			sum += lp.LogProb
+		}
+		return sum
+	}
+
+	dec := json.NewDecoder(strings.NewReader(content))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	confidences := make(map[string]float64)
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if keyTok != "labels" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil
+			}
+			continue
+		}
+
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil
+		}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil
+			}
+			label, ok := tok.(string)
+			if !ok {
+				continue
+			}
+			end := int(dec.InputOffset())
+			start := openingQuote(content, end)
+			if start < 0 {
+				return nil
+			}
+			conf := math.Exp(spanLogProb(start, end))
+			if conf > confidences[label] {
+				confidences[label] = conf
+			}
+		}
+		break
+	}
+	return confidences
+}
+
+// openingQuote returns the index of the quote opening the JSON string
+// that ends just before end.
+func openingQuote(content string, end int) int {
+	for i := end - 2; i >= 0; i-- {
+		if content[i] != '"' {
+			continue
+		}
+		backslashes := 0
+		for j := i - 1; j >= 0 && content[j] == '\\'; j-- {
+			backslashes++
+		}
+		if backslashes%2 == 0 {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,78 @@
+package labeler
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// tokens splits content into log probabilities, giving every token the
+// same probability.
+func tokens(prob float64, toks ...string) []openai.LogProb {
+	lps := make([]openai.LogProb, len(toks))
+	for i, tok := range toks {
+		lps[i] = openai.LogProb{Token: tok, LogProb: math.Log(prob)}
+	}
+	return lps
+}
+
+func TestLabelConfidences(t *testing.T) {
+	t.Parallel()
+	content := `{"reasoning":"crash","labels":["bug","area/ui"]}`
+
+	t.Run("JointProbability", func(t *testing.T) {
+		t.Parallel()
+		lps := tokens(0.5, `{"`, `reasoning`, `":"`, `crash`, `","`, `labels`, `":["`, `bug`, `","`, `area`, `/ui`, `"]}`)
+		got := labelConfidences(content, lps)
+		// "bug" spans the tokens `":["`, `bug` and `","`.
+		want := map[string]float64{
+			"bug":     0.125,
+			"area/ui": 0.0625,
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for label, w := range want {
+			if math.Abs(got[label]-w) > 1e-9 {
+				t.Fatalf("%s: got %v, want %v", label, got[label], w)
+			}
+		}
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		t.Parallel()
+		lps := []openai.LogProb{
+			{Token: "bytes:\\xe2", Bytes: []byte(`{"labels":["`), LogProb: 0},
+			{Token: "ü", Bytes: []byte("ü"), LogProb: math.Log(0.8)},
+			{Token: `"]}`, LogProb: 0},
+		}
+		got := labelConfidences(`{"labels":["ü"]}`, lps)
+		if math.Abs(got["ü"]-0.8) > 1e-9 {
+			t.Fatalf("got %v, want ü at 0.8", got)
+		}
+	})
+
+	t.Run("Escaped", func(t *testing.T) {
+		t.Parallel()
+		content := `{"labels":["say \"hi\""]}`
+		got := labelConfidences(content, tokens(0.9, content))
+		if _, ok := got[`say "hi"`]; !ok {
+			t.Fatalf("got %v, want the escaped label", got)
+		}
+	})
+
+	t.Run("NoLogProbs", func(t *testing.T) {
+		t.Parallel()
+		if got := labelConfidences(content, nil); got != nil {
+			t.Fatalf("got %v, want nil", got)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		t.Parallel()
+		if got := labelConfidences(content, tokens(0.5, `{"labels":[]}`)); got != nil {
+			t.Fatalf("got %v, want nil", got)
+		}
+	})
+}
@@ -0,0 +1,345 @@
+package labeler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v59/github"
+	"gopkg.in/yaml.v3"
+)
+
+type repoConfig struct {
+	Exclude []regexp.Regexp `json:"exclude"`
+	// Include, if set, is an allowlist. Only matching labels may be set.
+	Include      []regexp.Regexp   `yaml:"include"`
+	Edited       editedConfig      `yaml:"edited"`
+	PullRequests pullRequestConfig `yaml:"pull_requests"`
+
+	// MinConfidence is the confidence, from 0 to 1, the model must have
+	// in a label for it to be set. Raising it trades false adds for false
+	// removes.
+	MinConfidence float64 `yaml:"min_confidence"`
+	// Labels holds per-label policies keyed by label name or by a
+	// regular expression matching the whole name, wrapped in slashes,
+	// e.g. /area/.*/.
+	Labels map[string]labelConfig `yaml:"labels"`
+	// Exclusive are groups of labels of which at most one may be set.
+	Exclusive []exclusiveGroup `yaml:"exclusive"`
+
+	// Implies maps a label to the labels it implies, e.g. a regression is
+	// always a bug.
+	Implies map[string][]string `yaml:"implies"`
+	// ConflictsWith maps a label to labels that may not be set with it.
+	ConflictsWith map[string][]string `yaml:"conflicts_with"`
+	// RequiresOneOf are groups of labels of which every issue must carry
+	// at least one. Combine with Exclusive for exactly one.
+	RequiresOneOf [][]string `yaml:"requires_one_of"`
+
+	// Inscriptive controls automatic disabling of inscriptive labels.
+	Inscriptive inscriptiveConfig `yaml:"inscriptive"`
+
+	// Rules set labels on matching issues without asking the model.
+	Rules []assignRule `yaml:"rules"`
+
+	// Instructions are free-form guidance from the maintainers, added to
+	// the system messages.
+	Instructions string `yaml:"instructions"`
+	// Glossary explains repo-specific terms, e.g. "site" meaning the web
+	// dashboard.
+	Glossary map[string]string `yaml:"glossary"`
+
+	// Examples selects the past issues shown to the model.
+	Examples examplesConfig `yaml:"examples"`
+	// Duplicates suggests likely duplicates of opened issues.
+	Duplicates duplicatesConfig `yaml:"duplicates"`
+
+	// Inherit, if false, stops the repo's config from being merged over
+	// the org's.
+	Inherit *bool `yaml:"inherit"`
+
+	// labelPatterns are the regular expression keys of Labels, in key
+	// order.
+	labelPatterns []labelPattern
+}
+
+// Values of labelConfig.AppliesTo.
+const (
+	appliesToIssues       = "issues"
+	appliesToPullRequests = "pull_requests"
+	appliesToBoth         = "both"
+)
+
+// labelConfig is the policy for a label.
+type labelConfig struct {
+	// Guidance is added to the label's description in the prompt.
+	Guidance string `yaml:"guidance"`
+	// MinConfidence overrides the repo-wide MinConfidence.
+	MinConfidence *float64 `yaml:"min_confidence"`
+	// Authors are the author associations, e.g. NONE or CONTRIBUTOR,
+	// whose issues may get the label. If empty, any author's may.
+	Authors []string `yaml:"authors"`
+	// AppliesTo is issues, pull_requests or both. Defaults to both.
+	AppliesTo string `yaml:"applies_to"`
+}
+
+type labelPattern struct {
+	re     *regexp.Regexp
+	config labelConfig
+}
+
+// labelPatternKey returns the regular expression of a Labels key wrapped
+// in slashes. Any other key is a label name, even if it contains regular
+// expression syntax like "c++".
+func labelPatternKey(key string) (string, bool) {
+	if len(key) < 2 || !strings.HasPrefix(key, "/") || !strings.HasSuffix(key, "/") {
+		return "", false
+	}
+	return key[1 : len(key)-1], true
+}
+
+// init validates the config and compiles the label policy keys.
+func (c *repoConfig) init() error {
+	if err := c.checkInstructions(); err != nil {
+		return err
+	}
+	if err := c.Examples.check(); err != nil {
+		return err
+	}
+	if err := c.Duplicates.check(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(c.Labels))
+	for key := range c.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	c.labelPatterns = nil
+	for _, key := range keys {
+		lc := c.Labels[key]
+		switch lc.AppliesTo {
+		case "", appliesToIssues, appliesToPullRequests, appliesToBoth:
+		default:
+			return fmt.Errorf("labels.%s.applies_to: must be %s, %s or %s, got %q",
+				key, appliesToIssues, appliesToPullRequests, appliesToBoth, lc.AppliesTo)
+		}
+		expr, ok := labelPatternKey(key)
+		if !ok {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("labels.%s: %w", key, err)
+		}
+		c.labelPatterns = append(c.labelPatterns, labelPattern{re: re, config: lc})
+	}
+	return nil
+}
+
+// labelPolicy returns the policy for label. A policy keyed by the exact
+// name wins over patterns, and earlier patterns win over later ones.
+func (c *repoConfig) labelPolicy(label string) (labelConfig, bool) {
+	if _, pattern := labelPatternKey(label); !pattern {
+		if lc, ok := c.Labels[label]; ok {
+			return lc, true
+		}
+	}
+	for _, p := range c.labelPatterns {
+		if p.re.MatchString(label) {
+			return p.config, true
+		}
+	}
+	return labelConfig{}, false
+}
+
+// minConfidence returns the confidence threshold for label.
+func (c *repoConfig) minConfidence(label string) float64 {
+	if lc, ok := c.labelPolicy(label); ok && lc.MinConfidence != nil {
+		return *lc.MinConfidence
+	}
+	return c.MinConfidence
+}
+
+// checkPolicy reports whether the label's policy allows it on the target,
+// given whether it's a pull request and its author association.
+func (c *repoConfig) checkPolicy(label string, pullRequest bool, association string) bool {
+	lc, ok := c.labelPolicy(label)
+	if !ok {
+		return true
+	}
+	switch lc.AppliesTo {
+	case appliesToIssues:
+		if pullRequest {
+			return false
+		}
+	case appliesToPullRequests:
+		if !pullRequest {
+			return false
+		}
+	}
+	if len(lc.Authors) > 0 && !slices.ContainsFunc(lc.Authors, func(a string) bool {
+		return strings.EqualFold(a, association)
+	}) {
+		return false
+	}
+	return true
+}
+
+// defaultEditMinChange is used when edited.min_change is unset.
+const defaultEditMinChange = 0.3
+
+// editedConfig controls re-inference of edited issues.
+type editedConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinChange is the fraction of the title and body, from 0 to 1, that
+	// must have changed for the issue to be re-inferred.
+	MinChange float64 `yaml:"min_change"`
+}
+
+func (c *editedConfig) minChange() float64 {
+	if c.MinChange <= 0 {
+		return defaultEditMinChange
+	}
+	return c.MinChange
+}
+
+// pullRequestConfig controls labeling of pull requests.
+type pullRequestConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Labels restricts the labels that may be applied to pull requests.
+	// If empty, any label may be applied.
+	Labels []regexp.Regexp `yaml:"labels"`
+	// Only are labels meant only for pull requests. They are never
+	// applied to issues.
+	Only []regexp.Regexp `yaml:"only"`
+}
+
+func matchAny(res []regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *repoConfig) checkLabel(label string) bool {
+	if len(c.Include) > 0 && !matchAny(c.Include, label) {
+		return false
+	}
+	return !matchAny(c.Exclude, label)
+}
+
+// checkTarget reports whether label may be applied to a pull request or
+// an issue, depending on pullRequest.
+func (c *repoConfig) checkTarget(label string, pullRequest bool) bool {
+	if pullRequest {
+		return len(c.PullRequests.Labels) == 0 ||
+			matchAny(c.PullRequests.Labels, label)
+	}
+	return !matchAny(c.PullRequests.Only, label)
+}
+
+// configPath is where both a repo and its org's .github repo keep the
+// labeler config.
+const configPath = ".github/labeler.yml"
+
+// getConfigContent returns the raw config file in the repo, or "" if
+// there is none.
+func (s *Webhook) getConfigContent(ctx context.Context, client *github.Client,
+	owner, repo string,
+) (string, error) {
+	return s.configCache.Do(configKey(owner, repo), func() (string, error) {
+		fileContent, _, _, err := client.Repositories.GetContents(
+			ctx,
+			owner,
+			repo,
+			configPath,
+			&github.RepositoryContentGetOptions{},
+		)
+		if err != nil {
+			var githubErr *github.ErrorResponse
+			if errors.As(err, &githubErr) && githubErr.Response.StatusCode == http.StatusNotFound {
+				return "", nil
+			}
+			return "", fmt.Errorf("get contents: %w", err)
+		}
+		content, err := fileContent.GetContent()
+		if err != nil {
+			return "", fmt.Errorf("unmarshal content: %w", err)
+		}
+		return content, nil
+	}, configCacheTTL)
+}
+
+// getConfigFile returns the parsed config file in the repo, or nil if
+// there is none.
+func (s *Webhook) getConfigFile(ctx context.Context, client *github.Client,
+	owner, repo string,
+) (*yaml.Node, error) {
+	content, err := s.getConfigContent(ctx, client, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal([]byte(content), &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", owner, repo, err)
+	}
+	if doc.Kind == 0 {
+		// Missing or empty.
+		return nil, nil
+	}
+	return &doc, nil
+}
+
+// getEffectiveConfig returns the repo's config file merged over the org's
+// default config, which lives in the org's .github repo. It returns nil
+// if neither exists.
+func (s *Webhook) getEffectiveConfig(ctx context.Context, client *github.Client,
+	owner, repo string,
+) (*yaml.Node, error) {
+	doc, err := s.getConfigFile(ctx, client, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if repo != orgConfigRepo && inherits(doc) {
+		orgDoc, err := s.getConfigFile(ctx, client, owner, orgConfigRepo)
+		if err != nil {
+			return nil, fmt.Errorf("get org config: %w", err)
+		}
+		doc = mergeYAML(orgDoc, doc)
+	}
+	return doc, nil
+}
+
+func (s *Webhook) getRepoConfig(ctx context.Context, client *github.Client,
+	owner, repo string,
+) (*repoConfig, error) {
+	doc, err := s.getEffectiveConfig(ctx, client, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var config repoConfig
+	if doc != nil {
+		err = doc.Decode(&config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = config.init()
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,430 @@
+package labeler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ammario/tlru"
+	"github.com/coder/labeler/ghapi"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/go-github/v59/github"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed dashboard/*.html
+var dashboardFS embed.FS
+
+var dashboardTemplates = func() map[string]*template.Template {
+	pages := make(map[string]*template.Template)
+	for _, page := range []string{"index", "repo", "dryrun"} {
+		pages[page] = template.Must(template.New("layout.html").Funcs(template.FuncMap{
+			"percent": func(f float64) string {
+				return fmt.Sprintf("%.0f%%", f*100)
+			},
+		}).ParseFS(dashboardFS, "dashboard/layout.html", "dashboard/"+page+".html"))
+	}
+	return pages
+}()
+
+const (
+	sessionCookie    = "labeler_session"
+	oauthStateCookie = "labeler_oauth_state"
+	sessionTTL       = 8 * time.Hour
+	// dashboardDecisions is the number of recent decisions shown per repo.
+	dashboardDecisions = 50
+)
+
+// dashboardSession is a user signed in to the dashboard with GitHub.
+type dashboardSession struct {
+	login string
+	token string
+	// csrf guards the dashboard's forms.
+	csrf string
+}
+
+// principal lets the session reuse the API's repo access checks.
+func (d *dashboardSession) principal() *principal {
+	hash := sha256.Sum256([]byte(d.token))
+	return &principal{
+		github:    github.NewClient(nil).WithAuthToken(d.token),
+		tokenHash: hex.EncodeToString(hash[:]),
+		login:     d.login,
+	}
+}
+
+type sessionKey struct{}
+
+func sessionFromContext(ctx context.Context) *dashboardSession {
+	sess, _ := ctx.Value(sessionKey{}).(*dashboardSession)
+	return sess
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+func (s *Webhook) initDashboard() {
+	s.sessions = tlru.New[string](func(*dashboardSession) int {
+		return 1
+	}, 4096)
+	s.router.Route("/dashboard", func(r chi.Router) {
+		r.Get("/login", s.dashboardLogin)
+		r.Get("/callback", s.dashboardCallback)
+		r.Group(func(r chi.Router) {
+			r.Use(s.requireSession)
+			r.Get("/", s.dashboardIndex)
+			r.Post("/logout", s.dashboardLogout)
+			r.Get("/{installID}/{owner}/{repo}", s.dashboardRepo)
+			r.Post("/{installID}/{owner}/{repo}/dry-run", s.dashboardDryRun)
+		})
+	})
+}
+
+func (s *Webhook) dashboardLogin(w http.ResponseWriter, r *http.Request) {
+	state := randomHex(16)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/dashboard",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"client_id": {s.OAuthClientID},
+		"state":     {state},
+	}
+	http.Redirect(w, r, "https://github.com/login/oauth/authorize?"+q.Encode(), http.StatusFound)
+}
+
+// exchangeOAuthCode trades an OAuth code for a user access token.
+func (s *Webhook) exchangeOAuthCode(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"client_id":     {s.OAuthClientID},
+		"client_secret": {s.OAuthClientSecret},
+		"code":          {code},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://github.com/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("decode token response: %w", err)
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("%s: %s", body.Error, body.ErrorDescription)
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("no access token, status %d", resp.StatusCode)
+	}
+	return body.AccessToken, nil
+}
+
+func (s *Webhook) dashboardCallback(w http.ResponseWriter, r *http.Request) {
+	state, err := r.Cookie(oauthStateCookie)
+	if err != nil || state.Value == "" ||
+		subtle.ConstantTimeCompare([]byte(state.Value), []byte(r.URL.Query().Get("state"))) != 1 {
+		http.Error(w, "invalid OAuth state, try signing in again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/dashboard", MaxAge: -1})
+
+	token, err := s.exchangeOAuthCode(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		s.Log.Warn("oauth exchange", "error", err)
+		http.Error(w, "sign in failed", http.StatusUnauthorized)
+		return
+	}
+	user, _, err := github.NewClient(nil).WithAuthToken(token).Users.Get(r.Context(), "")
+	if err != nil {
+		http.Error(w, "get user: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	id := randomHex(32)
+	s.sessions.Set(id, &dashboardSession{
+		login: user.GetLogin(),
+		token: token,
+		csrf:  randomHex(16),
+	}, sessionTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/dashboard",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	s.Log.Info("dashboard sign in", "login", user.GetLogin())
+	http.Redirect(w, r, "/dashboard/", http.StatusFound)
+}
+
+func (s *Webhook) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil {
+			http.Redirect(w, r, "/dashboard/login", http.StatusFound)
+			return
+		}
+		sess, _, ok := s.sessions.Get(cookie.Value)
+		if !ok {
+			http.Redirect(w, r, "/dashboard/login", http.StatusFound)
+			return
+		}
+		if r.Method == http.MethodPost &&
+			subtle.ConstantTimeCompare([]byte(r.PostFormValue("csrf")), []byte(sess.csrf)) != 1 {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, sess)))
+	})
+}
+
+func (s *Webhook) dashboardLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		s.sessions.Delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/dashboard", MaxAge: -1})
+	http.Redirect(w, r, "/dashboard/", http.StatusFound)
+}
+
+func (s *Webhook) renderDashboard(w http.ResponseWriter, page string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplates[page].Execute(w, data)
+	if err != nil {
+		s.Log.Error("render dashboard", "page", page, "error", err)
+	}
+}
+
+type dashboardRepoLink struct {
+	InstallID int64
+	FullName  string
+}
+
+func (s *Webhook) dashboardIndex(w http.ResponseWriter, r *http.Request) {
+	sess := sessionFromContext(r.Context())
+	client := github.NewClient(nil).WithAuthToken(sess.token)
+
+	installs, err := ghapi.Page(
+		r.Context(),
+		client,
+		func(ctx context.Context, opt *github.ListOptions) ([]*github.Installation, *github.Response, error) {
+			return client.Apps.ListUserInstallations(ctx, opt)
+		},
+		100,
+	)
+	if err != nil {
+		http.Error(w, "list installations: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	var repos []dashboardRepoLink
+	for _, inst := range installs {
+		instRepos, err := ghapi.Page(
+			r.Context(),
+			client,
+			func(ctx context.Context, opt *github.ListOptions) ([]*github.Repository, *github.Response, error) {
+				list, resp, err := client.Apps.ListUserRepos(ctx, inst.GetID(), opt)
+				if err != nil {
+					return nil, resp, err
+				}
+				return list.Repositories, resp, nil
+			},
+			300,
+		)
+		if err != nil {
+			http.Error(w, "list repositories: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		for _, repo := range instRepos {
+			repos = append(repos, dashboardRepoLink{
+				InstallID: inst.GetID(),
+				FullName:  repo.GetFullName(),
+			})
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].FullName < repos[j].FullName
+	})
+
+	s.renderDashboard(w, "index", map[string]any{
+		"Login": sess.login,
+		"CSRF":  sess.csrf,
+		"Repos": repos,
+	})
+}
+
+// dashboardAddr authorizes the repo in the URL for the signed in user
+// and returns it with a client for its installation.
+func (s *Webhook) dashboardAddr(w http.ResponseWriter, r *http.Request) (repoAddr, *github.Client, bool) {
+	sess := sessionFromContext(r.Context())
+	addr := repoAddr{
+		InstallID: chi.URLParam(r, "installID"),
+		User:      chi.URLParam(r, "owner"),
+		Repo:      chi.URLParam(r, "repo"),
+	}
+
+	ok, err := s.auth.canRead(r.Context(), sess.principal(), addr)
+	if err != nil {
+		http.Error(w, "check repo access: "+err.Error(), http.StatusBadGateway)
+		return addr, nil, false
+	}
+	if !ok {
+		http.Error(w, "no read access to "+addr.User+"/"+addr.Repo, http.StatusForbidden)
+		return addr, nil, false
+	}
+
+	instConfig, err := s.AppConfig.InstallationConfig(addr.InstallID)
+	if err != nil {
+		http.Error(w, "get installation config: "+err.Error(), http.StatusInternalServerError)
+		return addr, nil, false
+	}
+	return addr, github.NewClient(instConfig.Client(r.Context())), true
+}
+
+type dashboardLabel struct {
+	Name, Description, Guidance, Disabled string
+}
+
+func (s *Webhook) dashboardRepo(w http.ResponseWriter, r *http.Request) {
+	sess := sessionFromContext(r.Context())
+	addr, client, ok := s.dashboardAddr(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+
+	data := map[string]any{
+		"Login":     sess.login,
+		"CSRF":      sess.csrf,
+		"InstallID": addr.InstallID,
+		"Repo":      addr.User + "/" + addr.Repo,
+		"Audit":     s.Audit != nil,
+	}
+	if s.Audit != nil {
+		decisions, err := s.Audit.ListDecisions(ctx, addr.User, addr.Repo, 0, dashboardDecisions)
+		if err != nil {
+			data["DecisionsError"] = err.Error()
+		}
+		data["Decisions"] = decisions
+	}
+
+	repoLabels, err := s.getRepoLabels(ctx, client, addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var labelNames []string
+	for _, label := range repoLabels {
+		labelNames = append(labelNames, label.GetName())
+	}
+
+	raw, err := s.getConfigContent(ctx, client, addr.User, addr.Repo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	data["Problems"] = LintConfig([]byte(raw), labelNames)
+
+	doc, err := s.getEffectiveConfig(ctx, client, addr.User, addr.Repo)
+	if err != nil {
+		data["ConfigError"] = err.Error()
+	} else if doc != nil {
+		effective, err := yaml.Marshal(doc)
+		if err != nil {
+			data["ConfigError"] = err.Error()
+		}
+		data["Config"] = string(effective)
+	}
+
+	config, err := s.getRepoConfig(ctx, client, addr.User, addr.Repo)
+	if err != nil {
+		data["ConfigError"] = err.Error()
+		config = &repoConfig{}
+	}
+	disabled := s.disabledLabels(ctx, client, addr, config, repoLabels, nil)
+	var labels []dashboardLabel
+	for _, label := range repoLabels {
+		policy, _ := config.labelPolicy(label.GetName())
+		labels = append(labels, dashboardLabel{
+			Name:        label.GetName(),
+			Description: label.GetDescription(),
+			Guidance:    policy.Guidance,
+			Disabled:    disabled[label.GetName()],
+		})
+	}
+	data["Labels"] = labels
+
+	s.renderDashboard(w, "repo", data)
+}
+
+func (s *Webhook) dashboardDryRun(w http.ResponseWriter, r *http.Request) {
+	sess := sessionFromContext(r.Context())
+	addr, _, ok := s.dashboardAddr(w, r)
+	if !ok {
+		return
+	}
+	issue, err := strconv.Atoi(strings.TrimPrefix(r.PostFormValue("issue"), "#"))
+	if err != nil || issue <= 0 {
+		http.Error(w, "issue must be a number", http.StatusBadRequest)
+		return
+	}
+
+	data := map[string]any{
+		"Login":     sess.login,
+		"CSRF":      sess.csrf,
+		"InstallID": addr.InstallID,
+		"Repo":      addr.User + "/" + addr.Repo,
+		"Issue":     issue,
+	}
+	resp, err := s.Infer(r.Context(), &InferRequest{
+		InstallID: addr.InstallID,
+		User:      addr.User,
+		Repo:      addr.Repo,
+		Issue:     issue,
+		TestMode:  true,
+	})
+	if err != nil {
+		data["Error"] = err.Error()
+	} else {
+		data["Response"] = resp
+	}
+	s.renderDashboard(w, "dryrun", data)
+}
@@ -0,0 +1,44 @@
+package labeler
+
+import (
+	"time"
+)
+
+// deliveryTTL is how long we remember a webhook delivery. GitHub only
+// redelivers automatically shortly after a failure, but manual
+// redeliveries from the app settings can come much later.
+const deliveryTTL = 24 * time.Hour
+
+type deliveryState string
+
+const (
+	deliveryProcessing deliveryState = "processing"
+	deliveryProcessed  deliveryState = "processed"
+)
+
+// claimDelivery records that we're starting on the delivery with the
+// given X-GitHub-Delivery ID. If the delivery was already seen, it
+// returns the recorded state and false.
+func (s *Webhook) claimDelivery(id string) (deliveryState, bool) {
+	s.deliveriesMu.Lock()
+	defer s.deliveriesMu.Unlock()
+
+	if state, _, ok := s.deliveries.Get(id); ok {
+		return state, false
+	}
+	s.deliveries.Set(id, deliveryProcessing, deliveryTTL)
+	return deliveryProcessing, true
+}
+
+// finishDelivery records the outcome of a claimed delivery. Failed
+// deliveries are forgotten so that a redelivery can try again.
+func (s *Webhook) finishDelivery(id string, succeeded bool) {
+	if id == "" {
+		return
+	}
+	if !succeeded {
+		s.deliveries.Delete(id)
+		return
+	}
+	s.deliveries.Set(id, deliveryProcessed, deliveryTTL)
+}
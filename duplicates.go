@@ -0,0 +1,339 @@
+package labeler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v59/github"
+)
+
+const (
+	defaultDuplicateMinSimilarity = 0.9
+	defaultDuplicateClosedDays    = 30
+	// maxDuplicates bounds the suggestions in a comment.
+	maxDuplicates = 3
+	// duplicateCandidates is how many nearest issues are considered.
+	duplicateCandidates = 10
+
+	// Every issue whose suggestions were rejected within
+	// duplicateRejectionWindow raises the repo's threshold by
+	// duplicateRejectionStep, up to maxDuplicateSimilarity.
+	duplicateRejectionWindow = 90 * 24 * time.Hour
+	duplicateRejectionStep   = 0.01
+	maxDuplicateSimilarity   = 0.99
+)
+
+// duplicatesConfig controls duplicate detection on opened issues.
+type duplicatesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSimilarity is the cosine similarity of two issues' embeddings,
+	// from 0 to 1, above which they're likely duplicates.
+	MinSimilarity float64 `yaml:"min_similarity"`
+	// Label, if set, is added to issues with likely duplicates, e.g.
+	// "duplicate?".
+	Label string `yaml:"label"`
+	// ClosedDays is how long after closing an issue is still suggested.
+	ClosedDays int `yaml:"closed_days"`
+}
+
+func (c *duplicatesConfig) check() error {
+	if c.MinSimilarity < 0 || c.MinSimilarity > 1 {
+		return fmt.Errorf("duplicates.min_similarity: must be between 0 and 1")
+	}
+	if c.ClosedDays < 0 {
+		return fmt.Errorf("duplicates.closed_days: must not be negative")
+	}
+	return nil
+}
+
+func (c *duplicatesConfig) minSimilarity() float64 {
+	if c.MinSimilarity == 0 {
+		return defaultDuplicateMinSimilarity
+	}
+	return c.MinSimilarity
+}
+
+func (c *duplicatesConfig) closedWindow() time.Duration {
+	days := c.ClosedDays
+	if days == 0 {
+		days = defaultDuplicateClosedDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// duplicateSuggestion is an issue suggested as a duplicate.
+type duplicateSuggestion struct {
+	Number     int
+	Similarity float64
+}
+
+// duplicatesMarkerRe matches the marker hiding the suggestions in the comment so
+// "/labeler not-duplicate" can find them, e.g.
+// <!-- labeler:duplicates 12=0.934,40=0.912 -->.
+var duplicatesMarkerRe = regexp.MustCompile(`<!-- labeler:duplicates ([0-9=.,]*) -->`)
+
+func duplicatesComment(suggestions []duplicateSuggestion, issues []*github.Issue) string {
+	var sb strings.Builder
+	sb.WriteString("This issue looks similar to:\n\n")
+	var marker []string
+	for i, sug := range suggestions {
+		issue := issues[i]
+		state := ""
+		if issue.GetState() == "closed" {
+			state = "closed, "
+		}
+		fmt.Fprintf(&sb, "- #%d %s (%s%.0f%% similar)\n", sug.Number, issue.GetTitle(), state, sug.Similarity*100)
+		marker = append(marker, fmt.Sprintf("%d=%.3f", sug.Number, sug.Similarity))
+	}
+	sb.WriteString("\nIf it isn't a duplicate, a maintainer can comment `/labeler not-duplicate` " +
+		"and I'll be more careful with suggestions in this repository.\n")
+	fmt.Fprintf(&sb, "<!-- labeler:duplicates %s -->\n", strings.Join(marker, ","))
+	return sb.String()
+}
+
+func parseDuplicatesMarker(body string) ([]duplicateSuggestion, bool) {
+	m := duplicatesMarkerRe.FindStringSubmatch(body)
+	if m == nil {
+		return nil, false
+	}
+	var suggestions []duplicateSuggestion
+	for _, pair := range strings.Split(m[1], ",") {
+		number, similarity, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+		sim, _ := strconv.ParseFloat(similarity, 64)
+		suggestions = append(suggestions, duplicateSuggestion{Number: n, Similarity: sim})
+	}
+	return suggestions, true
+}
+
+// findDuplicatesComment returns the bot's duplicate suggestions on the issue,
+// or nil if it hasn't made any.
+func (s *Webhook) findDuplicatesComment(ctx context.Context, client *github.Client,
+	owner, repo string, number int,
+) ([]duplicateSuggestion, error) {
+	bot, err := s.botLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	comments, _, err := client.Issues.ListComments(ctx, owner, repo, number, &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list comments: %w", err)
+	}
+	for _, c := range comments {
+		if c.GetUser().GetLogin() != bot {
+			continue
+		}
+		if suggestions, ok := parseDuplicatesMarker(c.GetBody()); ok {
+			return suggestions, nil
+		}
+	}
+	return nil, nil
+}
+
+// duplicateThreshold returns the repo's similarity threshold, raised by
+// rejected suggestions, and the rejected issue pairs.
+func (s *Webhook) duplicateThreshold(ctx context.Context, addr repoAddr,
+	config *duplicatesConfig,
+) (float64, map[[2]int]bool, error) {
+	threshold := config.minSimilarity()
+	rejected := make(map[[2]int]bool)
+	if s.Feedback == nil {
+		return threshold, rejected, nil
+	}
+	rs, err := s.Feedback.ListDuplicateRejections(ctx, addr.User, addr.Repo, time.Now().Add(-duplicateRejectionWindow))
+	if err != nil {
+		return 0, nil, fmt.Errorf("list duplicate rejections: %w", err)
+	}
+	issues := make(map[int]bool)
+	for _, r := range rs {
+		rejected[[2]int{min(r.Issue, r.Duplicate), max(r.Issue, r.Duplicate)}] = true
+		issues[r.Issue] = true
+	}
+	threshold += duplicateRejectionStep * float64(len(issues))
+	return min(threshold, max(maxDuplicateSimilarity, config.minSimilarity())), rejected, nil
+}
+
+// checkDuplicates comments on a newly opened issue with the open or
+// recently closed issues it likely duplicates.
+func (s *Webhook) checkDuplicates(ctx context.Context, client *github.Client,
+	job labelJob,
+) error {
+	config, err := s.getRepoConfig(ctx, client, job.User, job.Repo)
+	if err != nil {
+		return fmt.Errorf("get repo config: %w", err)
+	}
+	if !config.Duplicates.Enabled {
+		return nil
+	}
+	if s.Issues == nil || s.Embeddings == nil {
+		return errors.New("duplicate detection needs an issue store and embeddings")
+	}
+	addr := repoAddr{InstallID: job.InstallID, User: job.User, Repo: job.Repo}
+
+	// Don't suggest twice if the job is retried.
+	previous, err := s.findDuplicatesComment(ctx, client, job.User, job.Repo, job.Issue)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		return nil
+	}
+
+	target, _, err := client.Issues.Get(ctx, job.User, job.Repo, job.Issue)
+	if err != nil {
+		return fmt.Errorf("get issue: %w", err)
+	}
+	embedding, err := embedIssue(ctx, s.Embeddings, s.EmbeddingModel, target)
+	if err != nil {
+		return fmt.Errorf("embed issue: %w", err)
+	}
+	installID, err := strconv.ParseInt(job.InstallID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse install ID: %w", err)
+	}
+	nearest, err := s.Issues.Nearest(ctx, NearestQuery{
+		InstallID: installID,
+		User:      job.User,
+		Repo:      job.Repo,
+		Exclude:   job.Issue,
+		Embedding: embedding,
+		K:         duplicateCandidates,
+	})
+	if err != nil {
+		return fmt.Errorf("find nearest issues: %w", err)
+	}
+
+	threshold, rejected, err := s.duplicateThreshold(ctx, addr, &config.Duplicates)
+	if err != nil {
+		return err
+	}
+	var candidates []duplicateSuggestion
+	for _, n := range nearest {
+		similarity := 1 - n.Distance
+		pair := [2]int{min(job.Issue, n.Number), max(job.Issue, n.Number)}
+		if similarity < threshold || rejected[pair] {
+			continue
+		}
+		candidates = append(candidates, duplicateSuggestion{Number: n.Number, Similarity: similarity})
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	numbers := make([]int, len(candidates))
+	for i, c := range candidates {
+		numbers[i] = c.Number
+	}
+	issues, errs := fetchIssues(ctx, client, addr, numbers, nil)
+
+	var (
+		suggestions []duplicateSuggestion
+		suggested   []*github.Issue
+	)
+	for i, issue := range issues {
+		if errs[i] != nil {
+			// Likely deleted or transferred since it was indexed.
+			continue
+		}
+		// Only an earlier issue can be the original.
+		if !issue.GetCreatedAt().Before(target.GetCreatedAt().Time) {
+			continue
+		}
+		if issue.GetState() == "closed" &&
+			time.Since(issue.GetClosedAt().Time) > config.Duplicates.closedWindow() {
+			continue
+		}
+		suggestions = append(suggestions, candidates[i])
+		suggested = append(suggested, issue)
+		if len(suggestions) == maxDuplicates {
+			break
+		}
+	}
+	if len(suggestions) == 0 {
+		return nil
+	}
+
+	body := duplicatesComment(suggestions, suggested)
+	_, _, err = client.Issues.CreateComment(ctx, job.User, job.Repo, job.Issue, &github.IssueComment{
+		Body: &body,
+	})
+	if err != nil {
+		return fmt.Errorf("comment: %w", err)
+	}
+	if config.Duplicates.Label != "" {
+		_, _, err = client.Issues.AddLabelsToIssue(ctx, job.User, job.Repo, job.Issue,
+			[]string{config.Duplicates.Label})
+		if err != nil {
+			return fmt.Errorf("add %q: %w", config.Duplicates.Label, err)
+		}
+	}
+	s.Log.Info("suggested duplicates",
+		"repo", job.User+"/"+job.Repo,
+		"issue", job.Issue,
+		"duplicates", suggestions,
+		"threshold", threshold,
+	)
+	return nil
+}
+
+// rejectDuplicates handles "/labeler not-duplicate": it records the
+// bot's suggestions on the issue as wrong and removes the duplicate
+// label. It returns the rejected suggestions.
+func (s *Webhook) rejectDuplicates(ctx context.Context, client *github.Client,
+	job commandJob,
+) ([]duplicateSuggestion, error) {
+	suggestions, err := s.findDuplicatesComment(ctx, client, job.User, job.Repo, job.Issue)
+	if err != nil {
+		return nil, err
+	}
+	if len(suggestions) == 0 {
+		return nil, nil
+	}
+
+	if s.Feedback != nil {
+		for _, sug := range suggestions {
+			err := s.Feedback.RecordDuplicateRejection(ctx, DuplicateRejection{
+				InstallID:  job.InstallID,
+				User:       job.User,
+				Repo:       job.Repo,
+				Issue:      job.Issue,
+				Duplicate:  sug.Number,
+				Similarity: sug.Similarity,
+				Actor:      job.Commenter,
+				At:         time.Now(),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("record duplicate rejection: %w", err)
+			}
+		}
+	}
+
+	config, err := s.getRepoConfig(ctx, client, job.User, job.Repo)
+	if err != nil {
+		return nil, fmt.Errorf("get repo config: %w", err)
+	}
+	if label := config.Duplicates.Label; label != "" {
+		_, err := client.Issues.RemoveLabelForIssue(ctx, job.User, job.Repo, job.Issue, label)
+		if err != nil {
+			var githubErr *github.ErrorResponse
+			if !errors.As(err, &githubErr) || githubErr.Response.StatusCode != http.StatusNotFound {
+				return nil, fmt.Errorf("remove %q: %w", label, err)
+			}
+		}
+	}
+	return suggestions, nil
+}
@@ -0,0 +1,33 @@
+package labeler
+
+import (
+	"strings"
+)
+
+// textChange estimates how much of a text changed between two versions,
+// from 0 (identical) to 1 (nothing in common). It compares the multisets
+// of words on either side, so reflowing or reordering text barely counts
+// while filling in a one-line stub counts as a near-total rewrite.
+func textChange(before, after string) float64 {
+	beforeWords := strings.Fields(strings.ToLower(before))
+	afterWords := strings.Fields(strings.ToLower(after))
+	total := len(beforeWords) + len(afterWords)
+	if total == 0 {
+		return 0
+	}
+
+	counts := make(map[string]int, len(beforeWords))
+	for _, w := range beforeWords {
+		counts[w]++
+	}
+	var common int
+	for _, w := range afterWords {
+		if counts[w] > 0 {
+			counts[w]--
+			common++
+		}
+	}
+
+	// One minus the Sørensen–Dice coefficient.
+	return 1 - float64(2*common)/float64(total)
+}
@@ -0,0 +1,35 @@
+package labeler
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTextChange(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name          string
+		before, after string
+		want          float64
+	}{
+		{"Empty", "", "", 0},
+		{"Identical", "crash on start", "crash on start", 0},
+		{"CaseAndSpacing", "Crash on  start", "crash\non start", 0},
+		{"Reordered", "on start crash", "crash on start", 0},
+		{"FromEmpty", "", "crash on start", 1},
+		{"ToEmpty", "crash on start", "", 1},
+		{"Disjoint", "todo", "the dashboard panics when saving", 1},
+		// 2 of 4 + 4 words in common.
+		{"Half", "crash on start now", "crash on exit later", 0.5},
+		{"Repeated", "a a", "a", 1 - 2.0/3},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := textChange(tt.before, tt.after)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("textChange(%q, %q) = %v, want %v", tt.before, tt.after, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package labeler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/google/go-github/v59/github"
+)
+
+// Values of examplesConfig.Mode.
+const (
+	examplesRecent  = "recent"
+	examplesSimilar = "similar"
+)
+
+const (
+	defaultSimilarExamples = 30
+	defaultRecentExamples  = 10
+	maxSimilarExamples     = 100
+	// issueFetchers bounds concurrent fetches of issues.
+	issueFetchers = 8
+)
+
+// examplesConfig selects the past issues shown to the model as examples.
+type examplesConfig struct {
+	// Mode is recent, the default, for the most recent issues, or similar
+	// for the issues nearest to the target by embedding, mixed with a few
+	// recent ones.
+	Mode string `yaml:"mode"`
+	// Similar is the number of similar labeled issues in similar mode.
+	Similar int `yaml:"similar"`
+	// Recent is the number of recent issues mixed in in similar mode.
+	Recent int `yaml:"recent"`
+}
+
+func (c *examplesConfig) check() error {
+	switch c.Mode {
+	case "", examplesRecent, examplesSimilar:
+	default:
+		return fmt.Errorf("examples.mode: must be %s or %s, got %q", examplesRecent, examplesSimilar, c.Mode)
+	}
+	if c.Similar < 0 || c.Similar > maxSimilarExamples {
+		return fmt.Errorf("examples.similar: must be between 0 and %d", maxSimilarExamples)
+	}
+	if c.Recent < 0 {
+		return fmt.Errorf("examples.recent: must not be negative")
+	}
+	return nil
+}
+
+func (c *examplesConfig) similar() int {
+	if c.Similar == 0 {
+		return defaultSimilarExamples
+	}
+	return c.Similar
+}
+
+func (c *examplesConfig) recent() int {
+	if c.Recent == 0 {
+		return defaultRecentExamples
+	}
+	return c.Recent
+}
+
+// similarIssues returns up to k labeled issues nearest to target. The
+// index doesn't hold labels, so the issues come from GitHub unless they're
+// among known.
+func (s *Webhook) similarIssues(ctx context.Context, client *github.Client,
+	addr repoAddr, target *github.Issue, known []*github.Issue, k int,
+) ([]*github.Issue, error) {
+	embedding, err := embedIssue(ctx, s.Embeddings, s.EmbeddingModel, target)
+	if err != nil {
+		return nil, fmt.Errorf("embed target: %w", err)
+	}
+	installID, err := strconv.ParseInt(addr.InstallID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse install ID: %w", err)
+	}
+	nearest, err := s.Issues.Nearest(ctx, NearestQuery{
+		InstallID:   installID,
+		User:        addr.User,
+		Repo:        addr.Repo,
+		PullRequest: target.IsPullRequest(),
+		Exclude:     target.GetNumber(),
+		Embedding:   embedding,
+		// Some of the nearest will be unlabeled.
+		K: 2 * k,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find nearest issues: %w", err)
+	}
+
+	byNumber := make(map[int]*github.Issue, len(known))
+	for _, issue := range known {
+		byNumber[issue.GetNumber()] = issue
+	}
+
+	numbers := make([]int, len(nearest))
+	for i, n := range nearest {
+		numbers[i] = n.Number
+	}
+	issues, errs := fetchIssues(ctx, client, addr, numbers, byNumber)
+
+	var similar []*github.Issue
+	for i, issue := range issues {
+		if errs[i] != nil {
+			// Likely deleted or transferred since it was indexed.
+			s.Log.Debug("get similar issue", "number", numbers[i], "error", errs[i])
+			continue
+		}
+		if len(issue.Labels) == 0 {
+			continue
+		}
+		similar = append(similar, issue)
+		if len(similar) == k {
+			break
+		}
+	}
+	return similar, nil
+}
+
+// fetchIssues gets the numbered issues from GitHub, or from known when
+// they're in it, concurrently. The results are in the order of numbers.
+func fetchIssues(ctx context.Context, client *github.Client, addr repoAddr,
+	numbers []int, known map[int]*github.Issue,
+) ([]*github.Issue, []error) {
+	var (
+		issues = make([]*github.Issue, len(numbers))
+		errs   = make([]error, len(numbers))
+		sem    = make(chan struct{}, issueFetchers)
+		wg     sync.WaitGroup
+	)
+	for i, number := range numbers {
+		if issue, ok := known[number]; ok {
+			issues[i] = issue
+			continue
+		}
+		wg.Add(1)
+		go func(i, number int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			issues[i], _, errs[i] = client.Issues.Get(ctx, addr.User, addr.Repo, number)
+		}(i, number)
+	}
+	wg.Wait()
+	return issues, errs
+}
+
+// mixExamples returns the config's similar issues to target mixed with
+// its most recent ones from recent, which is sorted by creation. It falls
+// back to recent if similar issues can't be found.
+func (s *Webhook) mixExamples(ctx context.Context, client *github.Client,
+	addr repoAddr, config *examplesConfig, target *github.Issue, recent []*github.Issue,
+) []*github.Issue {
+	log := s.Log.With("repo", addr.User+"/"+addr.Repo, "issue", target.GetNumber())
+	if s.Issues == nil || s.Embeddings == nil {
+		log.Warn("similar examples need an issue store and embeddings, using recent issues")
+		return recent
+	}
+
+	similar, err := s.similarIssues(ctx, client, addr, target, recent, config.similar())
+	if err != nil {
+		// Labeling from recent issues beats not labeling.
+		log.Warn("find similar issues, using recent issues", "error", err)
+		return recent
+	}
+
+	examples := slices.Clone(recent[max(0, len(recent)-config.recent()):])
+	seen := make(map[int]bool, len(examples))
+	for _, issue := range examples {
+		seen[issue.GetNumber()] = true
+	}
+	for _, issue := range similar {
+		if !seen[issue.GetNumber()] {
+			examples = append(examples, issue)
+		}
+	}
+	sort.Slice(examples, func(i, j int) bool {
+		return examples[i].GetCreatedAt().Before(examples[j].GetCreatedAt().Time)
+	})
+	log.Debug("mixed similar examples", "similar", len(similar), "examples", len(examples))
+	return examples
+}
@@ -0,0 +1,85 @@
+package labeler
+
+import (
+	"regexp"
+	"slices"
+)
+
+// exclusiveGroup is a set of labels of which an issue should carry at
+// most one, e.g. severities.
+type exclusiveGroup struct {
+	Name string `yaml:"name"`
+	// Labels and Match select the members of the group. A label is a
+	// member if it is listed or matches.
+	Labels []string       `yaml:"labels"`
+	Match  *regexp.Regexp `yaml:"match"`
+	// Replace lets the bot swap a member the issue already carries for
+	// the one it infers. Otherwise a member set by a human or an issue
+	// template always wins.
+	Replace bool `yaml:"replace"`
+}
+
+func (g *exclusiveGroup) contains(label string) bool {
+	if slices.Contains(g.Labels, label) {
+		return true
+	}
+	return g.Match != nil && g.Match.MatchString(label)
+}
+
+// exclusiveDecision records how a group was resolved.
+type exclusiveDecision struct {
+	Group   string   `json:"group"`
+	Kept    string   `json:"kept,omitempty"`
+	Dropped []string `json:"dropped,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// applyExclusive resolves each group in labels to its highest-confidence
+// member. Without confidences, the member the model listed first wins.
+// It returns the labels to set, the existing labels to remove and what
+// was decided for each group that needed it.
+func applyExclusive(groups []exclusiveGroup, labels []string,
+	confidences map[string]float64, existing []string,
+) ([]string, []string, []exclusiveDecision) {
+	var (
+		remove    []string
+		decisions []exclusiveDecision
+	)
+	for _, g := range groups {
+		members := filterSlice(labels, g.contains)
+		if len(members) == 0 {
+			continue
+		}
+		best := members[0]
+		for _, m := range members[1:] {
+			if confidences[m] > confidences[best] {
+				best = m
+			}
+		}
+		current := filterSlice(existing, func(label string) bool {
+			return label != best && g.contains(label)
+		})
+		if len(members) == 1 && len(current) == 0 {
+			continue
+		}
+
+		d := exclusiveDecision{Group: g.Name, Kept: best}
+		switch {
+		case len(current) > 0 && !slices.Contains(existing, best) && !g.Replace:
+			// The issue already has a member and we may not replace it.
+			d.Kept = ""
+		case len(current) > 0 && g.Replace:
+			d.Removed = current
+			remove = append(remove, current...)
+		}
+		labels = filterSlice(labels, func(label string) bool {
+			if !g.contains(label) || label == d.Kept {
+				return true
+			}
+			d.Dropped = append(d.Dropped, label)
+			return false
+		})
+		decisions = append(decisions, d)
+	}
+	return labels, remove, decisions
+}
@@ -0,0 +1,106 @@
+package labeler
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestApplyExclusive(t *testing.T) {
+	t.Parallel()
+	severity := exclusiveGroup{Name: "severity", Labels: []string{"s1", "s2", "s3"}}
+	priority := exclusiveGroup{Name: "priority", Match: regexp.MustCompile(`^p\d$`)}
+
+	for _, tt := range []struct {
+		name        string
+		group       exclusiveGroup
+		labels      []string
+		confidences map[string]float64
+		existing    []string
+		wantLabels  []string
+		wantRemove  []string
+		wantKept    string
+		wantDecided bool
+	}{
+		{
+			name:       "SingleMember",
+			group:      severity,
+			labels:     []string{"bug", "s2"},
+			wantLabels: []string{"bug", "s2"},
+		},
+		{
+			name:        "HighestConfidence",
+			group:       severity,
+			labels:      []string{"s1", "bug", "s3"},
+			confidences: map[string]float64{"s1": 0.4, "s3": 0.9},
+			wantLabels:  []string{"bug", "s3"},
+			wantKept:    "s3",
+			wantDecided: true,
+		},
+		{
+			name:        "FirstListed",
+			group:       severity,
+			labels:      []string{"s2", "s1"},
+			wantLabels:  []string{"s2"},
+			wantKept:    "s2",
+			wantDecided: true,
+		},
+		{
+			name:        "Match",
+			group:       priority,
+			labels:      []string{"p1", "p2", "s1"},
+			confidences: map[string]float64{"p1": 0.2, "p2": 0.7},
+			wantLabels:  []string{"p2", "s1"},
+			wantKept:    "p2",
+			wantDecided: true,
+		},
+		{
+			name:        "ExistingWins",
+			group:       severity,
+			labels:      []string{"bug", "s1"},
+			existing:    []string{"s3"},
+			wantLabels:  []string{"bug"},
+			wantDecided: true,
+		},
+		{
+			name:       "ExistingSame",
+			group:      severity,
+			labels:     []string{"s1"},
+			existing:   []string{"s1"},
+			wantLabels: []string{"s1"},
+		},
+		{
+			name: "Replace",
+			group: exclusiveGroup{
+				Name:    "severity",
+				Labels:  []string{"s1", "s2", "s3"},
+				Replace: true,
+			},
+			labels:      []string{"bug", "s1"},
+			existing:    []string{"s3", "docs"},
+			wantLabels:  []string{"bug", "s1"},
+			wantRemove:  []string{"s3"},
+			wantKept:    "s1",
+			wantDecided: true,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			labels, remove, decisions := applyExclusive([]exclusiveGroup{tt.group},
+				tt.labels, tt.confidences, tt.existing)
+			if !slices.Equal(labels, tt.wantLabels) {
+				t.Fatalf("got labels %v, want %v", labels, tt.wantLabels)
+			}
+			if !slices.Equal(remove, tt.wantRemove) {
+				t.Fatalf("got remove %v, want %v", remove, tt.wantRemove)
+			}
+			if (len(decisions) == 1) != tt.wantDecided {
+				t.Fatalf("got decisions %+v, want decided %v", decisions, tt.wantDecided)
+			}
+			if tt.wantDecided && decisions[0].Kept != tt.wantKept {
+				t.Fatalf("got kept %q, want %q", decisions[0].Kept, tt.wantKept)
+			}
+		})
+	}
+}
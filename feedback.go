@@ -0,0 +1,565 @@
+package labeler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/coder/labeler/httpjson"
+)
+
+// correctionWindow is how long after labeling an issue we treat human
+// label changes as corrections of the bot. Later changes are more likely
+// to reflect the issue evolving than the bot being wrong.
+const correctionWindow = 7 * 24 * time.Hour
+
+// LabelApplication records the labels the bot set on an issue. One is
+// recorded every time the bot labels an issue, even if it set nothing, so
+// labels humans add afterwards count as misses.
+type LabelApplication struct {
+	InstallID string    `json:"install_id"`
+	User      string    `json:"user"`
+	Repo      string    `json:"repo"`
+	Issue     int       `json:"issue"`
+	Labels    []string  `json:"labels"`
+	AppliedAt time.Time `json:"applied_at"`
+	// Disabled are the labels the bot could not have set, so a human
+	// adding one of them isn't a miss.
+	Disabled []string `json:"disabled,omitempty"`
+}
+
+type CorrectionKind string
+
+const (
+	// CorrectionFalseAdd is a human removing a label the bot added.
+	CorrectionFalseAdd CorrectionKind = "false_add"
+	// CorrectionFalseRemove is a human adding a label the bot missed.
+	CorrectionFalseRemove CorrectionKind = "false_remove"
+)
+
+// LabelCorrection is a human label change that contradicts the bot.
+type LabelCorrection struct {
+	InstallID string         `json:"install_id"`
+	User      string         `json:"user"`
+	Repo      string         `json:"repo"`
+	Issue     int            `json:"issue"`
+	Label     string         `json:"label"`
+	Kind      CorrectionKind `json:"kind"`
+	Actor     string         `json:"actor"`
+	At        time.Time      `json:"at"`
+	// Reverted is set when the human undid their own correction, e.g.
+	// removed a bot label and then put it back.
+	Reverted bool `json:"reverted,omitempty"`
+}
+
+// DuplicateRejection is a maintainer marking a duplicate suggestion as
+// wrong.
+type DuplicateRejection struct {
+	InstallID string `json:"install_id"`
+	User      string `json:"user"`
+	Repo      string `json:"repo"`
+	Issue     int    `json:"issue"`
+	// Duplicate is the issue suggested as a duplicate of Issue.
+	Duplicate  int       `json:"duplicate"`
+	Similarity float64   `json:"similarity"`
+	Actor      string    `json:"actor"`
+	At         time.Time `json:"at"`
+}
+
+// FeedbackStore persists what the bot labeled and how humans corrected it.
+type FeedbackStore interface {
+	RecordApplication(ctx context.Context, app LabelApplication) error
+	RecordCorrection(ctx context.Context, c LabelCorrection) error
+	// ListApplications returns applications in the repo since the given
+	// time, oldest first.
+	ListApplications(ctx context.Context, user, repo string, since time.Time) ([]LabelApplication, error)
+	// ListIssueApplications returns the applications on an issue within
+	// correctionWindow, oldest first. It's called for every human label
+	// change, so it must not scan the store.
+	ListIssueApplications(ctx context.Context, user, repo string, issue int) ([]LabelApplication, error)
+	// ListCorrections returns corrections in the repo since the given
+	// time, oldest first.
+	ListCorrections(ctx context.Context, user, repo string, since time.Time) ([]LabelCorrection, error)
+
+	RecordDuplicateRejection(ctx context.Context, r DuplicateRejection) error
+	// ListDuplicateRejections returns rejections in the repo since the
+	// given time, oldest first.
+	ListDuplicateRejections(ctx context.Context, user, repo string, since time.Time) ([]DuplicateRejection, error)
+}
+
+// feedbackSchema holds the feedback tables. Label lists are JSON arrays
+// and times are Unix nanoseconds.
+const feedbackSchema = `
+CREATE TABLE IF NOT EXISTS applications (
+	install_id TEXT NOT NULL,
+	user       TEXT NOT NULL COLLATE NOCASE,
+	repo       TEXT NOT NULL COLLATE NOCASE,
+	issue      INTEGER NOT NULL,
+	labels     TEXT NOT NULL,
+	applied_at INTEGER NOT NULL,
+	disabled   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS applications_by_issue ON applications (user, repo, issue, applied_at);
+CREATE INDEX IF NOT EXISTS applications_by_time ON applications (user, repo, applied_at);
+
+CREATE TABLE IF NOT EXISTS corrections (
+	install_id TEXT NOT NULL,
+	user       TEXT NOT NULL COLLATE NOCASE,
+	repo       TEXT NOT NULL COLLATE NOCASE,
+	issue      INTEGER NOT NULL,
+	label      TEXT NOT NULL,
+	kind       TEXT NOT NULL,
+	actor      TEXT NOT NULL,
+	at         INTEGER NOT NULL,
+	reverted   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS corrections_by_time ON corrections (user, repo, at);
+
+CREATE TABLE IF NOT EXISTS duplicate_rejections (
+	install_id TEXT NOT NULL,
+	user       TEXT NOT NULL COLLATE NOCASE,
+	repo       TEXT NOT NULL COLLATE NOCASE,
+	issue      INTEGER NOT NULL,
+	duplicate  INTEGER NOT NULL,
+	similarity REAL NOT NULL,
+	actor      TEXT NOT NULL,
+	at         INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS duplicate_rejections_by_time ON duplicate_rejections (user, repo, at);
+`
+
+type sqliteFeedbackStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteFeedbackStore stores feedback in a SQLite database in dir.
+func NewSQLiteFeedbackStore(dir string) (FeedbackStore, error) {
+	db, err := openSQLite(filepath.Join(dir, "feedback.db"), feedbackSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteFeedbackStore{db: db}, nil
+}
+
+const applicationColumns = "install_id, user, repo, issue, labels, applied_at, disabled"
+
+// applicationFields returns pointers to app's fields in applicationColumns
+// order, for both inserting and scanning.
+func applicationFields(app *LabelApplication, appliedAt *int64) []any {
+	return []any{
+		&app.InstallID, &app.User, &app.Repo, &app.Issue,
+		jsonColumn[[]string]{&app.Labels}, appliedAt, jsonColumn[[]string]{&app.Disabled},
+	}
+}
+
+func (s *sqliteFeedbackStore) RecordApplication(ctx context.Context, app LabelApplication) error {
+	appliedAt := app.AppliedAt.UnixNano()
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO applications ("+applicationColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		applicationFields(&app, &appliedAt)...,
+	)
+	if err != nil {
+		return fmt.Errorf("insert application: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteFeedbackStore) listApplications(ctx context.Context, query string, args ...any) ([]LabelApplication, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query applications: %w", err)
+	}
+	defer rows.Close()
+
+	var apps []LabelApplication
+	for rows.Next() {
+		var (
+			app       LabelApplication
+			appliedAt int64
+		)
+		err := rows.Scan(applicationFields(&app, &appliedAt)...)
+		if err != nil {
+			return nil, fmt.Errorf("read application: %w", err)
+		}
+		app.AppliedAt = time.Unix(0, appliedAt)
+		apps = append(apps, app)
+	}
+	return apps, rows.Err()
+}
+
+func (s *sqliteFeedbackStore) ListApplications(ctx context.Context, user, repo string, since time.Time) ([]LabelApplication, error) {
+	return s.listApplications(ctx, `
+	SELECT `+applicationColumns+`
+	FROM applications
+	WHERE user = ? AND repo = ? AND applied_at >= ?
+	ORDER BY applied_at, rowid
+	`, user, repo, since.UnixNano())
+}
+
+func (s *sqliteFeedbackStore) ListIssueApplications(ctx context.Context, user, repo string, issue int) ([]LabelApplication, error) {
+	return s.listApplications(ctx, `
+	SELECT `+applicationColumns+`
+	FROM applications
+	WHERE user = ? AND repo = ? AND issue = ? AND applied_at >= ?
+	ORDER BY applied_at, rowid
+	`, user, repo, issue, time.Now().Add(-correctionWindow).UnixNano())
+}
+
+func (s *sqliteFeedbackStore) RecordCorrection(ctx context.Context, c LabelCorrection) error {
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO corrections (install_id, user, repo, issue, label, kind, actor, at, reverted)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, c.InstallID, c.User, c.Repo, c.Issue, c.Label, string(c.Kind), c.Actor, c.At.UnixNano(), c.Reverted)
+	if err != nil {
+		return fmt.Errorf("insert correction: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteFeedbackStore) ListCorrections(ctx context.Context, user, repo string, since time.Time) ([]LabelCorrection, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT install_id, user, repo, issue, label, kind, actor, at, reverted
+	FROM corrections
+	WHERE user = ? AND repo = ? AND at >= ?
+	ORDER BY at, rowid
+	`, user, repo, since.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("query corrections: %w", err)
+	}
+	defer rows.Close()
+
+	var cs []LabelCorrection
+	for rows.Next() {
+		var (
+			c  LabelCorrection
+			at int64
+		)
+		err := rows.Scan(&c.InstallID, &c.User, &c.Repo, &c.Issue, &c.Label, &c.Kind, &c.Actor, &at, &c.Reverted)
+		if err != nil {
+			return nil, fmt.Errorf("read correction: %w", err)
+		}
+		c.At = time.Unix(0, at)
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
+func (s *sqliteFeedbackStore) RecordDuplicateRejection(ctx context.Context, r DuplicateRejection) error {
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO duplicate_rejections (install_id, user, repo, issue, duplicate, similarity, actor, at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.InstallID, r.User, r.Repo, r.Issue, r.Duplicate, r.Similarity, r.Actor, r.At.UnixNano())
+	if err != nil {
+		return fmt.Errorf("insert duplicate rejection: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteFeedbackStore) ListDuplicateRejections(ctx context.Context, user, repo string, since time.Time) ([]DuplicateRejection, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT install_id, user, repo, issue, duplicate, similarity, actor, at
+	FROM duplicate_rejections
+	WHERE user = ? AND repo = ? AND at >= ?
+	ORDER BY at, rowid
+	`, user, repo, since.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("query duplicate rejections: %w", err)
+	}
+	defer rows.Close()
+
+	var rs []DuplicateRejection
+	for rows.Next() {
+		var (
+			r  DuplicateRejection
+			at int64
+		)
+		err := rows.Scan(&r.InstallID, &r.User, &r.Repo, &r.Issue, &r.Duplicate, &r.Similarity, &r.Actor, &at)
+		if err != nil {
+			return nil, fmt.Errorf("read duplicate rejection: %w", err)
+		}
+		r.At = time.Unix(0, at)
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+// recordApplication records the labels the bot set on an issue, which may
+// be none.
+func (s *Webhook) recordApplication(ctx context.Context, log *slog.Logger,
+	job labelJob, labels, disabled []string,
+) {
+	if s.Feedback == nil {
+		return
+	}
+	err := s.Feedback.RecordApplication(ctx, LabelApplication{
+		InstallID: job.InstallID,
+		User:      job.User,
+		Repo:      job.Repo,
+		Issue:     job.Issue,
+		Labels:    labels,
+		AppliedAt: time.Now(),
+		Disabled:  disabled,
+	})
+	if err != nil {
+		// The labels are already set, so retrying the job would only
+		// double-count them.
+		log.Error("record label application", "error", err)
+	}
+}
+
+// recordLabelChange checks a human label change against the bot's recent
+// applications on the issue and records it if it's a correction.
+func (s *Webhook) recordLabelChange(ctx context.Context, addr repoAddr,
+	issue int, label string, added bool, actor string,
+) error {
+	if s.Feedback == nil {
+		return nil
+	}
+
+	apps, err := s.Feedback.ListIssueApplications(ctx, addr.User, addr.Repo, issue)
+	if err != nil {
+		return fmt.Errorf("list applications: %w", err)
+	}
+	if len(apps) == 0 {
+		// Not labeled by us recently.
+		return nil
+	}
+	c, ok := classifyLabelChange(apps, label, added)
+	if !ok {
+		return nil
+	}
+	c.InstallID = addr.InstallID
+	c.User = addr.User
+	c.Repo = addr.Repo
+	c.Issue = issue
+	c.Actor = actor
+	c.At = time.Now()
+
+	s.Log.Info("label correction",
+		"repo", addr.User+"/"+addr.Repo,
+		"issue", issue,
+		"label", label,
+		"kind", c.Kind,
+		"reverted", c.Reverted,
+		"actor", actor,
+	)
+	return s.Feedback.RecordCorrection(ctx, c)
+}
+
+// classifyLabelChange returns the correction a human adding or removing
+// label is, given the bot's applications on the issue, or false if it
+// isn't one. A removal is matched against every application, since a
+// re-label on edit only records the labels it added.
+func classifyLabelChange(apps []LabelApplication, label string, added bool) (LabelCorrection, bool) {
+	var botAdded, disabled bool
+	for _, app := range apps {
+		botAdded = botAdded || slices.Contains(app.Labels, label)
+		disabled = disabled || slices.Contains(app.Disabled, label)
+	}
+
+	c := LabelCorrection{Label: label}
+	switch {
+	case botAdded && !added:
+		c.Kind = CorrectionFalseAdd
+	case botAdded && added:
+		c.Kind, c.Reverted = CorrectionFalseAdd, true
+	case disabled:
+		return c, false
+	case added:
+		c.Kind = CorrectionFalseRemove
+	default:
+		c.Kind, c.Reverted = CorrectionFalseRemove, true
+	}
+	return c, true
+}
+
+// accuracyBucket mirrors the categories reported by the test command.
+type accuracyBucket struct {
+	Start  time.Time `json:"start"`
+	Issues int       `json:"issues"`
+
+	Hits         int `json:"hits"`
+	FalseAdds    int `json:"false_adds"`
+	FalseRemoves int `json:"false_removes"`
+
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+
+	TopFalseAdds    []string `json:"top_false_adds,omitempty"`
+	TopFalseRemoves []string `json:"top_false_removes,omitempty"`
+}
+
+func topLabels(counts map[string]int, n int) []string {
+	labels := make([]string, 0, len(counts))
+	for label := range counts {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if counts[labels[i]] != counts[labels[j]] {
+			return counts[labels[i]] > counts[labels[j]]
+		}
+		return labels[i] < labels[j]
+	})
+	if len(labels) > n {
+		labels = labels[:n]
+	}
+	return labels
+}
+
+// accuracyOverTime scores each issue, in the bucket of its first
+// application, against the corrections that followed. Every label any of
+// its applications set is scored, so a re-label on edit doesn't hide the
+// earlier labels. Only the latest correction of a label on an issue
+// counts, so a reverted correction cancels out. apps must be oldest first.
+func accuracyOverTime(apps []LabelApplication, corrections []LabelCorrection,
+	since time.Time, bucket time.Duration,
+) []accuracyBucket {
+	type issueLabel struct {
+		issue int
+		label string
+	}
+	latest := make(map[issueLabel]LabelCorrection)
+	for _, c := range corrections {
+		latest[issueLabel{c.Issue, c.Label}] = c
+	}
+
+	type issueApps struct {
+		issue       int
+		first, last time.Time
+		// setAt is when each label was first set.
+		setAt  map[string]time.Time
+		labels []string
+	}
+	var (
+		issues  []*issueApps
+		byIssue = make(map[int]*issueApps)
+	)
+	for _, app := range apps {
+		ia, ok := byIssue[app.Issue]
+		if !ok {
+			ia = &issueApps{
+				issue: app.Issue,
+				first: app.AppliedAt,
+				setAt: make(map[string]time.Time),
+			}
+			byIssue[app.Issue] = ia
+			issues = append(issues, ia)
+		}
+		ia.last = app.AppliedAt
+		for _, label := range app.Labels {
+			if _, ok := ia.setAt[label]; !ok {
+				ia.setAt[label] = app.AppliedAt
+				ia.labels = append(ia.labels, label)
+			}
+		}
+	}
+
+	var (
+		buckets        []accuracyBucket
+		falseAddCounts []map[string]int
+		falseRmCounts  []map[string]int
+	)
+	for _, ia := range issues {
+		i := int(ia.first.Sub(since) / bucket)
+		for len(buckets) <= i {
+			buckets = append(buckets, accuracyBucket{
+				Start: since.Add(time.Duration(len(buckets)) * bucket),
+			})
+			falseAddCounts = append(falseAddCounts, make(map[string]int))
+			falseRmCounts = append(falseRmCounts, make(map[string]int))
+		}
+		b := &buckets[i]
+		b.Issues++
+		for _, label := range ia.labels {
+			c, ok := latest[issueLabel{ia.issue, label}]
+			if ok && c.Kind == CorrectionFalseAdd && !c.Reverted && c.At.After(ia.setAt[label]) {
+				b.FalseAdds++
+				falseAddCounts[i][label]++
+				continue
+			}
+			b.Hits++
+		}
+		for key, c := range latest {
+			if key.issue != ia.issue || c.Kind != CorrectionFalseRemove || c.Reverted {
+				continue
+			}
+			if _, ok := ia.setAt[key.label]; ok {
+				continue
+			}
+			if c.At.Before(ia.first) || c.At.After(ia.last.Add(correctionWindow)) {
+				continue
+			}
+			b.FalseRemoves++
+			falseRmCounts[i][key.label]++
+		}
+	}
+
+	for i := range buckets {
+		b := &buckets[i]
+		if b.Hits+b.FalseAdds > 0 {
+			b.Precision = float64(b.Hits) / float64(b.Hits+b.FalseAdds)
+		}
+		if b.Hits+b.FalseRemoves > 0 {
+			b.Recall = float64(b.Hits) / float64(b.Hits+b.FalseRemoves)
+		}
+		b.TopFalseAdds = topLabels(falseAddCounts[i], 5)
+		b.TopFalseRemoves = topLabels(falseRmCounts[i], 5)
+	}
+	return buckets
+}
+
+func (s *Webhook) accuracy(w http.ResponseWriter, r *http.Request) *httpjson.Response {
+	if s.Feedback == nil {
+		return &httpjson.Response{
+			Status: http.StatusNotFound,
+			Body:   httpjson.M{"error": "feedback is not enabled"},
+		}
+	}
+
+	var (
+		user   = r.URL.Query().Get("user")
+		repo   = r.URL.Query().Get("repo")
+		window = 90 * 24 * time.Hour
+		bucket = 7 * 24 * time.Hour
+		err    error
+	)
+	if v := r.URL.Query().Get("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			return &httpjson.Response{
+				Status: http.StatusBadRequest,
+				Body:   httpjson.M{"error": "window must be a positive duration"},
+			}
+		}
+	}
+	if v := r.URL.Query().Get("bucket"); v != "" {
+		bucket, err = time.ParseDuration(v)
+		if err != nil || bucket <= 0 {
+			return &httpjson.Response{
+				Status: http.StatusBadRequest,
+				Body:   httpjson.M{"error": "bucket must be a positive duration"},
+			}
+		}
+	}
+
+	since := time.Now().Add(-window).Truncate(bucket)
+	apps, err := s.Feedback.ListApplications(r.Context(), user, repo, since)
+	if err != nil {
+		return s.serverError(err)
+	}
+	corrections, err := s.Feedback.ListCorrections(r.Context(), user, repo, since)
+	if err != nil {
+		return s.serverError(err)
+	}
+
+	return &httpjson.Response{
+		Status: http.StatusOK,
+		Body:   accuracyOverTime(apps, corrections, since, bucket),
+	}
+}
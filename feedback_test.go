@@ -0,0 +1,188 @@
+package labeler
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestClassifyLabelChange(t *testing.T) {
+	t.Parallel()
+	apps := []LabelApplication{
+		{Labels: []string{"bug"}, Disabled: []string{"wontfix"}},
+		// A re-label on edit only records what it added.
+		{Labels: []string{"area/ui"}},
+	}
+
+	for _, tt := range []struct {
+		name         string
+		label        string
+		added        bool
+		want         CorrectionKind
+		wantReverted bool
+		wantOK       bool
+	}{
+		{"RemovedBotLabel", "bug", false, CorrectionFalseAdd, false, true},
+		{"RemovedEarlierBotLabel", "area/ui", false, CorrectionFalseAdd, false, true},
+		{"ReaddedBotLabel", "bug", true, CorrectionFalseAdd, true, true},
+		{"AddedMissedLabel", "docs", true, CorrectionFalseRemove, false, true},
+		{"RemovedMissedLabel", "docs", false, CorrectionFalseRemove, true, true},
+		{"AddedDisabledLabel", "wontfix", true, "", false, false},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c, ok := classifyLabelChange(apps, tt.label, tt.added)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if c.Kind != tt.want || c.Reverted != tt.wantReverted || c.Label != tt.label {
+				t.Fatalf("got %+v, want kind %s, reverted %v", c, tt.want, tt.wantReverted)
+			}
+		})
+	}
+}
+
+func TestAccuracyOverTime(t *testing.T) {
+	t.Parallel()
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+	day := 24 * time.Hour
+
+	apps := []LabelApplication{
+		// Week 0: issue 1 gets bug, then area/ui on edit.
+		{Issue: 1, Labels: []string{"bug"}, AppliedAt: since.Add(day)},
+		{Issue: 1, Labels: []string{"area/ui"}, AppliedAt: since.Add(2 * day)},
+		// Week 0: the bot sets nothing on issue 2.
+		{Issue: 2, AppliedAt: since.Add(3 * day)},
+		// Week 1: issue 3 gets docs.
+		{Issue: 3, Labels: []string{"docs"}, AppliedAt: since.Add(week + day)},
+	}
+	corrections := []LabelCorrection{
+		// bug was wrong, on the earlier application.
+		{Issue: 1, Label: "bug", Kind: CorrectionFalseAdd, At: since.Add(4 * day)},
+		// Issue 2 needed a label the bot didn't set.
+		{Issue: 2, Label: "question", Kind: CorrectionFalseRemove, At: since.Add(4 * day)},
+		// docs was removed and put back.
+		{Issue: 3, Label: "docs", Kind: CorrectionFalseAdd, At: since.Add(week + 2*day)},
+		{Issue: 3, Label: "docs", Kind: CorrectionFalseAdd, Reverted: true, At: since.Add(week + 3*day)},
+	}
+
+	buckets := accuracyOverTime(apps, corrections, since, week)
+	if len(buckets) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(buckets))
+	}
+
+	b := buckets[0]
+	if b.Issues != 2 || b.Hits != 1 || b.FalseAdds != 1 || b.FalseRemoves != 1 {
+		t.Fatalf("week 0: got %+v, want 2 issues, 1 hit, 1 false add, 1 false remove", b)
+	}
+	if b.Precision != 0.5 || b.Recall != 0.5 {
+		t.Fatalf("week 0: got precision %v, recall %v, want 0.5, 0.5", b.Precision, b.Recall)
+	}
+	if len(b.TopFalseAdds) != 1 || b.TopFalseAdds[0] != "bug" {
+		t.Fatalf("week 0: got top false adds %v, want [bug]", b.TopFalseAdds)
+	}
+	if len(b.TopFalseRemoves) != 1 || b.TopFalseRemoves[0] != "question" {
+		t.Fatalf("week 0: got top false removes %v, want [question]", b.TopFalseRemoves)
+	}
+
+	b = buckets[1]
+	if b.Issues != 1 || b.Hits != 1 || b.FalseAdds != 0 || b.FalseRemoves != 0 {
+		t.Fatalf("week 1: got %+v, want 1 issue, 1 hit", b)
+	}
+	if !b.Start.Equal(since.Add(week)) {
+		t.Fatalf("week 1: got start %v, want %v", b.Start, since.Add(week))
+	}
+}
+
+func TestSQLiteFeedbackStore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewSQLiteFeedbackStore(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	now := time.Now()
+	for _, app := range []LabelApplication{
+		{User: "coder", Repo: "coder", Issue: 1, Labels: []string{"stale"}, AppliedAt: now.Add(-2 * correctionWindow)},
+		{User: "coder", Repo: "coder", Issue: 1, Labels: []string{"bug"}, AppliedAt: now.Add(-time.Hour), Disabled: []string{"wontfix"}},
+		{User: "coder", Repo: "coder", Issue: 1, AppliedAt: now},
+		{User: "coder", Repo: "coder", Issue: 2, Labels: []string{"docs"}, AppliedAt: now},
+		{User: "coder", Repo: "vscode-coder", Issue: 1, Labels: []string{"bug"}, AppliedAt: now},
+	} {
+		if err := store.RecordApplication(ctx, app); err != nil {
+			t.Fatalf("record application: %v", err)
+		}
+	}
+	err = store.RecordCorrection(ctx, LabelCorrection{
+		User: "coder", Repo: "coder", Issue: 1, Label: "bug",
+		Kind: CorrectionFalseAdd, Actor: "maintainer", At: now, Reverted: true,
+	})
+	if err != nil {
+		t.Fatalf("record correction: %v", err)
+	}
+	err = store.RecordDuplicateRejection(ctx, DuplicateRejection{
+		User: "coder", Repo: "coder", Issue: 2, Duplicate: 1, Similarity: 0.93, Actor: "maintainer", At: now,
+	})
+	if err != nil {
+		t.Fatalf("record duplicate rejection: %v", err)
+	}
+
+	check := func(store FeedbackStore) {
+		t.Helper()
+		apps, err := store.ListIssueApplications(ctx, "Coder", "Coder", 1)
+		if err != nil {
+			t.Fatalf("list issue applications: %v", err)
+		}
+		if len(apps) != 2 || !slices.Equal(apps[0].Labels, []string{"bug"}) ||
+			!slices.Equal(apps[0].Disabled, []string{"wontfix"}) || len(apps[1].Labels) != 0 {
+			t.Fatalf("got %+v, want the bug and the empty application", apps)
+		}
+
+		apps, err = store.ListApplications(ctx, "coder", "coder", now.Add(-3*correctionWindow))
+		if err != nil {
+			t.Fatalf("list applications: %v", err)
+		}
+		if len(apps) != 4 || apps[0].Labels[0] != "stale" || !apps[3].AppliedAt.Equal(now) {
+			t.Fatalf("got %+v, want the repo's 4 applications, oldest first", apps)
+		}
+
+		cs, err := store.ListCorrections(ctx, "coder", "coder", now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("list corrections: %v", err)
+		}
+		if len(cs) != 1 || cs[0].Kind != CorrectionFalseAdd || !cs[0].Reverted || cs[0].Actor != "maintainer" {
+			t.Fatalf("got corrections %+v, want the reverted false add", cs)
+		}
+		cs, err = store.ListCorrections(ctx, "coder", "coder", now.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("list later corrections: %v", err)
+		}
+		if len(cs) != 0 {
+			t.Fatalf("got corrections %+v, want none", cs)
+		}
+
+		rs, err := store.ListDuplicateRejections(ctx, "coder", "coder", now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("list duplicate rejections: %v", err)
+		}
+		if len(rs) != 1 || rs[0].Duplicate != 1 || rs[0].Similarity != 0.93 {
+			t.Fatalf("got rejections %+v, want the rejection of #1", rs)
+		}
+	}
+	check(store)
+
+	// Feedback survives a restart.
+	store, err = NewSQLiteFeedbackStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check(store)
+}
@@ -54,3 +54,16 @@ func OnlyTrueIssues(
 	}
 	return result
 }
+
+func OnlyPullRequests(
+	slice []*github.Issue,
+) []*github.Issue {
+	var result []*github.Issue
+	for _, item := range slice {
+		if !item.IsPullRequest() {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
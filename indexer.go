@@ -8,20 +8,23 @@ import (
 	"strings"
 	"time"
 
-	"cloud.google.com/go/bigquery"
 	"github.com/beatlabs/github-auth/app"
 	"github.com/coder/labeler/ghapi"
+	"github.com/coder/labeler/llm"
 	"github.com/google/go-github/v59/github"
 	"github.com/sashabaranov/go-openai"
-	"google.golang.org/api/iterator"
 )
 
 type Indexer struct {
 	Log           *slog.Logger
-	OpenAI        *openai.Client
 	AppConfig     *app.Config
-	BigQuery      *bigquery.Client
+	Issues        IssueStore
 	IndexInterval time.Duration
+
+	// LLM serves embeddings with EmbeddingModel, which defaults to
+	// text-embedding-3-small.
+	LLM            llm.Provider
+	EmbeddingModel string
 }
 
 func (s *Indexer) findRandInstall(ctx context.Context) (*github.Installation, error) {
@@ -56,7 +59,11 @@ func f32to64(f []float32) []float64 {
 	return out
 }
 
-func (s *Indexer) embedIssue(ctx context.Context, issue *github.Issue) ([]float64, error) {
+// embedIssue embeds an issue for the issues table, with the given model or
+// text-embedding-3-small.
+func embedIssue(ctx context.Context, provider llm.Provider, model string,
+	issue *github.Issue,
+) ([]float64, error) {
 	var buf strings.Builder
 	fmt.Fprintf(&buf, "Title: %s\n", issue.GetTitle())
 	fmt.Fprintf(&buf, "State: %s\n", issue.GetState())
@@ -67,94 +74,32 @@ func (s *Indexer) embedIssue(ctx context.Context, issue *github.Issue) ([]float6
 	}
 	fmt.Fprintf(&buf, "Labels: %s\n", strings.Join(labelNames, ", "))
 	fmt.Fprintf(&buf, "Body: %s\n", issue.GetBody())
+	return embedText(ctx, provider, model, buf.String())
+}
 
-	tokens := tokenize(buf.String())
+// embedText embeds text like embedIssue, e.g. for searching the issues
+// table.
+func embedText(ctx context.Context, provider llm.Provider, model string,
+	text string,
+) ([]float64, error) {
+	tokens := tokenize(text)
 	if len(tokens) > 8191 {
 		tokens = tokens[:8191]
 	}
-	resp, err := s.OpenAI.CreateEmbeddings(
+	if model == "" {
+		model = string(openai.SmallEmbedding3)
+	}
+	embeddings, err := provider.Embed(
 		ctx,
-		&openai.EmbeddingRequestStrings{
-			Model:      openai.SmallEmbedding3,
-			Input:      []string{strings.Join(tokens, "")},
-			Dimensions: embeddingDimensions,
-		},
+		model,
+		embeddingDimensions,
+		[]string{strings.Join(tokens, "")},
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(resp.Data) != 1 {
-		return nil, fmt.Errorf("expected 1 embedding, got %d", len(resp.Data))
-	}
-
-	return f32to64(resp.Data[0].Embedding), nil
-}
-
-// issuesTableName is incremented with major schema changes since DML on
-// active tables is very slow.
-const issuesTableName = "issues_v2"
-
-func (s *Indexer) issuesTable() *bigquery.Table {
-	return s.BigQuery.Dataset("ghindex").Table(issuesTableName)
-}
-
-// getUpdatedAts helps avoid duplicate inserts by letting the caller skip over
-// issues that have already been indexed.
-func (s *Indexer) getUpdatedAts(ctx context.Context, installID int64) (map[int64]time.Time, error) {
-	queryStr := `
-	WITH RankedIssues AS (
-	  SELECT
-		id,
-		updated_at,
-		inserted_at,
-		ROW_NUMBER() OVER (PARTITION BY inserted_at, id ORDER BY inserted_at DESC) AS rn
-	  FROM
-		` + "`coder-labeler.ghindex." + issuesTableName + "`" + `
-	  WHERE install_id = @install_id
-	)
-	SELECT
-	  id,
-	  updated_at
-	FROM
-	  RankedIssues
-	WHERE
-	  rn = 1
-	ORDER BY
-	  inserted_at DESC;
-	`
-
-	q := s.BigQuery.Query(queryStr)
-	q.Parameters = []bigquery.QueryParameter{
-		{
-			Name:  "install_id",
-			Value: installID,
-		},
-	}
-
-	job, err := q.Run(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("run query: %w", err)
-	}
-	iter, err := job.Read(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("read query: %w", err)
-	}
-
-	issues := make(map[int64]time.Time)
-	for {
-		var i BqIssue
-		err := iter.Next(&i)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			s.Log.Error("read issue", "error", err)
-			break
-		}
-		issues[i.ID] = i.UpdatedAt
-	}
-	return issues, nil
+	return f32to64(embeddings[0]), nil
 }
 
 // indexInstall indexes all the issues for an installation.
@@ -187,10 +132,7 @@ func (s *Indexer) indexInstall(ctx context.Context, install *github.Installation
 	log := s.Log.With("install", install.GetID())
 	log.Debug("indexing install", "repos", len(repos))
 
-	table := s.issuesTable()
-	inserter := table.Inserter()
-
-	cachedIssues, err := s.getUpdatedAts(ctx, install.GetID())
+	cachedIssues, err := s.Issues.UpdatedAts(ctx, install.GetID())
 	if err != nil {
 		return fmt.Errorf("get cached issues: %w", err)
 	}
@@ -224,11 +166,11 @@ func (s *Indexer) indexInstall(ctx context.Context, install *github.Installation
 					continue
 				}
 			}
-			emb, err := s.embedIssue(ctx, issue)
+			emb, err := embedIssue(ctx, s.LLM, s.EmbeddingModel, issue)
 			if err != nil {
 				return fmt.Errorf("embed issue %v: %w", issue.ID, err)
 			}
-			err = inserter.Put(ctx, BqIssue{
+			err = s.Issues.PutIssue(ctx, BqIssue{
 				ID:          issue.GetID(),
 				InstallID:   install.GetID(),
 				User:        repo.GetOwner().GetLogin(),
@@ -0,0 +1,144 @@
+package labeler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coder/labeler/httpjson"
+	"gopkg.in/yaml.v3"
+)
+
+// orgConfigRepo is the org repo holding defaults for all of its repos,
+// the same convention GitHub uses for community health files.
+const orgConfigRepo = ".github"
+
+// configCacheTTL bounds how stale a config can get if we miss the push
+// event that changed it.
+const configCacheTTL = 10 * time.Minute
+
+func configKey(owner, repo string) string {
+	return strings.ToLower(owner + "/" + repo)
+}
+
+// inherits reports whether the repo config doc, which may be nil, should
+// be merged over the org's.
+func inherits(doc *yaml.Node) bool {
+	if doc == nil {
+		return true
+	}
+	var v struct {
+		Inherit *bool `yaml:"inherit"`
+	}
+	if err := doc.Decode(&v); err != nil {
+		// Decoding the full config will report it.
+		return true
+	}
+	return v.Inherit == nil || *v.Inherit
+}
+
+// mergeYAML merges over onto base, either of which may be nil:
+//
+//   - Mappings are merged key by key.
+//   - Sequences are concatenated, base first.
+//   - Anything else in over replaces base.
+func mergeYAML(base, over *yaml.Node) *yaml.Node {
+	switch {
+	case base == nil:
+		return over
+	case over == nil:
+		return base
+	}
+
+	if base.Kind == yaml.DocumentNode && over.Kind == yaml.DocumentNode &&
+		len(base.Content) == 1 && len(over.Content) == 1 {
+		merged := *over
+		merged.Content = []*yaml.Node{mergeYAML(base.Content[0], over.Content[0])}
+		return &merged
+	}
+
+	switch {
+	case base.Kind == yaml.MappingNode && over.Kind == yaml.MappingNode:
+		merged := *over
+		merged.Content = slices.Clone(base.Content)
+		for i := 0; i+1 < len(over.Content); i += 2 {
+			key, value := over.Content[i], over.Content[i+1]
+			if j := mappingIndex(merged.Content, key.Value); j >= 0 {
+				merged.Content[j+1] = mergeYAML(merged.Content[j+1], value)
+				continue
+			}
+			merged.Content = append(merged.Content, key, value)
+		}
+		return &merged
+	case base.Kind == yaml.SequenceNode && over.Kind == yaml.SequenceNode:
+		merged := *over
+		merged.Content = append(slices.Clone(base.Content), over.Content...)
+		return &merged
+	default:
+		return over
+	}
+}
+
+// mappingIndex returns the index of key in the content of a mapping
+// node, or -1.
+func mappingIndex(content []*yaml.Node, key string) int {
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// pushEvent evicts cached configs changed by a push.
+func (s *Webhook) pushEvent(body []byte) *httpjson.Response {
+	// Decode just what we need so we don't depend on the full shape of
+	// the push payload.
+	var push struct {
+		Repository struct {
+			Name  string `json:"name"`
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		} `json:"repository"`
+		Commits []struct {
+			Added    []string `json:"added"`
+			Removed  []string `json:"removed"`
+			Modified []string `json:"modified"`
+		} `json:"commits"`
+	}
+	err := json.Unmarshal(body, &push)
+	if err != nil {
+		return s.serverError(fmt.Errorf("unmarshal push: %w", err))
+	}
+
+	touched := false
+	for _, c := range push.Commits {
+		if slices.Contains(c.Added, configPath) ||
+			slices.Contains(c.Removed, configPath) ||
+			slices.Contains(c.Modified, configPath) {
+			touched = true
+			break
+		}
+	}
+	// GitHub lists at most 20 commits per push, so a larger push may
+	// have touched the config without saying so.
+	if !touched && len(push.Commits) < 20 {
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"message": "config unchanged"},
+		}
+	}
+
+	owner, repo := push.Repository.Owner.Login, push.Repository.Name
+	s.configCache.Delete(configKey(owner, repo))
+	s.Log.Debug("evicted config cache", "repo", owner+"/"+repo)
+
+	return &httpjson.Response{
+		Status: http.StatusOK,
+		Body:   httpjson.M{"message": "evicted config cache"},
+	}
+}
@@ -0,0 +1,140 @@
+package labeler
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseYAML(t *testing.T, s string) *yaml.Node {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return &doc
+}
+
+func TestMergeYAML(t *testing.T) {
+	t.Parallel()
+	base := `
+model: gpt-4o
+exclude:
+  - wontfix
+descriptions:
+  bug: Something is broken.
+  docs: Documentation.
+`
+	for _, tt := range []struct {
+		name string
+		base string
+		over string
+		want string
+	}{
+		{
+			name: "NoOver",
+			base: base,
+			want: base,
+		},
+		{
+			name: "NoBase",
+			over: "model: gpt-4o-mini\n",
+			want: "model: gpt-4o-mini\n",
+		},
+		{
+			name: "ScalarReplaced",
+			base: base,
+			over: "model: gpt-4o-mini\n",
+			want: `
+model: gpt-4o-mini
+exclude:
+  - wontfix
+descriptions:
+  bug: Something is broken.
+  docs: Documentation.
+`,
+		},
+		{
+			name: "SequencesConcatenated",
+			base: base,
+			over: "exclude: [duplicate]\n",
+			want: `
+model: gpt-4o
+exclude: [wontfix, duplicate]
+descriptions:
+  bug: Something is broken.
+  docs: Documentation.
+`,
+		},
+		{
+			name: "MappingsMerged",
+			base: base,
+			over: `
+descriptions:
+  docs: Docs only.
+  area/ui: The dashboard.
+inherit: true
+`,
+			want: `
+model: gpt-4o
+exclude:
+  - wontfix
+descriptions:
+  bug: Something is broken.
+  docs: Docs only.
+  area/ui: The dashboard.
+inherit: true
+`,
+		},
+		{
+			name: "KindChanged",
+			base: base,
+			over: "exclude: none\n",
+			want: `
+model: gpt-4o
+exclude: none
+descriptions:
+  bug: Something is broken.
+  docs: Documentation.
+`,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var baseDoc, overDoc *yaml.Node
+			if tt.base != "" {
+				baseDoc = parseYAML(t, tt.base)
+			}
+			if tt.over != "" {
+				overDoc = parseYAML(t, tt.over)
+			}
+			var got, want any
+			if err := mergeYAML(baseDoc, overDoc).Decode(&got); err != nil {
+				t.Fatalf("decode merged: %v", err)
+			}
+			if err := parseYAML(t, tt.want).Decode(&want); err != nil {
+				t.Fatalf("decode want: %v", err)
+			}
+			gotOut, _ := yaml.Marshal(got)
+			wantOut, _ := yaml.Marshal(want)
+			if string(gotOut) != string(wantOut) {
+				t.Fatalf("got:\n%s\nwant:\n%s", gotOut, wantOut)
+			}
+		})
+	}
+}
+
+func TestMergeYAMLKeepsBase(t *testing.T) {
+	t.Parallel()
+	base := parseYAML(t, "exclude: [wontfix]\n")
+	_ = mergeYAML(base, parseYAML(t, "exclude: [duplicate]\n"))
+
+	var got struct{ Exclude []string }
+	if err := base.Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got.Exclude) != 1 || got.Exclude[0] != "wontfix" {
+		t.Fatalf("base changed to %v", got.Exclude)
+	}
+}
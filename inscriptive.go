@@ -0,0 +1,203 @@
+package labeler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/coder/labeler/ghapi"
+	"github.com/google/go-github/v59/github"
+)
+
+// Inscriptive labels add information a human decided on, like wontfix or
+// roadmap, rather than describing the issue. The model can't infer them,
+// so we find them from how humans have applied them in the past:
+//
+//   - They're usually added long after the issue was opened.
+//   - They're added by someone other than the author, e.g. a maintainer.
+//   - Which issues get them has little to do with what the issues say.
+const (
+	// inscriptiveLate is how long after an issue was opened a label must
+	// be added to count as late.
+	inscriptiveLate = 24 * time.Hour
+	// inscriptiveMinAdds is the number of human adds needed to judge a
+	// label.
+	inscriptiveMinAdds = 5
+	// inscriptiveMinLate and inscriptiveMinByOthers are the fractions of
+	// adds that must be late and by someone other than the author.
+	inscriptiveMinLate     = 0.5
+	inscriptiveMinByOthers = 0.8
+	// inscriptiveMaxAssociation is the highest word association of a
+	// label whose issues don't read any differently from the rest.
+	inscriptiveMaxAssociation = 0.5
+
+	// inscriptiveEvents bounds the label history we fetch per repo.
+	inscriptiveEvents = 3000
+	// inscriptiveTTL is how long the analysis of a repo is cached.
+	inscriptiveTTL = 24 * time.Hour
+)
+
+// inscriptiveConfig controls automatic disabling of inscriptive labels.
+type inscriptiveConfig struct {
+	// AutoDisable is off by default, since a wrong guess silently stops
+	// the bot from setting a label.
+	AutoDisable bool `yaml:"auto_disable"`
+}
+
+// labelHistory summarizes how humans added a label.
+type labelHistory struct {
+	Adds     int `json:"adds"`
+	Late     int `json:"late"`
+	ByOthers int `json:"by_others"`
+	// Association is how far the label's most telling word moves the
+	// chance of the label from its base rate toward certainty, from 0
+	// for no more than chance to 1 for always.
+	Association float64 `json:"association"`
+}
+
+// inscriptive reports whether the history marks the label as
+// inscriptive, and why.
+func (h labelHistory) inscriptive() (string, bool) {
+	if h.Adds < inscriptiveMinAdds {
+		return "", false
+	}
+	late := float64(h.Late) / float64(h.Adds)
+	byOthers := float64(h.ByOthers) / float64(h.Adds)
+	if late < inscriptiveMinLate || byOthers < inscriptiveMinByOthers ||
+		h.Association >= inscriptiveMaxAssociation {
+		return "", false
+	}
+	return fmt.Sprintf(
+		"inscriptive: %.0f%% of %d adds came over a day after opening, "+
+			"%.0f%% by someone other than the author, and it doesn't "+
+			"correlate with issue content (association %.2f)",
+		late*100, h.Adds, byOthers*100, h.Association,
+	), true
+}
+
+// contentWords returns the distinct words of an issue worth correlating
+// with labels.
+func contentWords(issue *github.Issue) map[string]struct{} {
+	words := make(map[string]struct{})
+	text := strings.ToLower(issue.GetTitle() + " " + issue.GetBody())
+	for _, w := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) >= 3 {
+			words[w] = struct{}{}
+		}
+	}
+	return words
+}
+
+// labelHistories replays issue events into a history per label. Adds by
+// bots, including issue templates and ourselves, are ignored.
+func labelHistories(events []*github.IssueEvent) map[string]*labelHistory {
+	var (
+		histories = make(map[string]*labelHistory)
+		// labeled holds the issues each label was added to.
+		labeled = make(map[string]map[int]struct{})
+		issues  = make(map[int]map[string]struct{})
+	)
+	for _, ev := range events {
+		issue := ev.GetIssue()
+		if _, ok := issues[issue.GetNumber()]; !ok {
+			issues[issue.GetNumber()] = contentWords(issue)
+		}
+		if ev.GetEvent() != "labeled" || ev.GetActor().GetType() == "Bot" {
+			continue
+		}
+
+		name := ev.GetLabel().GetName()
+		h, ok := histories[name]
+		if !ok {
+			h = &labelHistory{}
+			histories[name] = h
+			labeled[name] = make(map[int]struct{})
+		}
+		h.Adds++
+		if ev.GetCreatedAt().Sub(issue.GetCreatedAt().Time) > inscriptiveLate {
+			h.Late++
+		}
+		if ev.GetActor().GetLogin() != issue.GetUser().GetLogin() {
+			h.ByOthers++
+		}
+		labeled[name][issue.GetNumber()] = struct{}{}
+	}
+
+	// How often each word appears across all issues.
+	wordIssues := make(map[string]int)
+	for _, words := range issues {
+		for w := range words {
+			wordIssues[w]++
+		}
+	}
+
+	// A label's association is the most any word moves the chance of the
+	// label toward certainty, (P(label|word) - P(label)) / (1 - P(label)),
+	// among words common enough in its issues not to be noise. Unlike the
+	// lift, P(label|word) / P(label), it isn't capped by 1 / P(label), so
+	// it works for labels on most issues too.
+	for name, h := range histories {
+		nLabeled := len(labeled[name])
+		pLabel := float64(nLabeled) / float64(len(issues))
+		if pLabel >= 1 {
+			// No word can tell its issues apart.
+			continue
+		}
+		minSupport := max(3, nLabeled/5)
+
+		wordLabeled := make(map[string]int)
+		for number := range labeled[name] {
+			for w := range issues[number] {
+				wordLabeled[w]++
+			}
+		}
+		for w, n := range wordLabeled {
+			if n < minSupport {
+				continue
+			}
+			pGiven := float64(n) / float64(wordIssues[w])
+			association := (pGiven - pLabel) / (1 - pLabel)
+			if association > h.Association {
+				h.Association = association
+			}
+		}
+	}
+	return histories
+}
+
+// getInscriptiveLabels returns the repo's inscriptive labels and why
+// they're considered so. The analysis is cached for a day.
+func (s *Webhook) getInscriptiveLabels(ctx context.Context, client *github.Client,
+	addr repoAddr,
+) (map[string]string, error) {
+	return s.inscriptiveCache.Do(addr, func() (map[string]string, error) {
+		events, err := ghapi.Page(
+			ctx,
+			client,
+			func(ctx context.Context, opt *github.ListOptions) ([]*github.IssueEvent, *github.Response, error) {
+				return client.Issues.ListRepositoryEvents(ctx, addr.User, addr.Repo, opt)
+			},
+			inscriptiveEvents,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("list repository events: %w", err)
+		}
+
+		inscriptive := make(map[string]string)
+		for name, h := range labelHistories(events) {
+			if reason, ok := h.inscriptive(); ok {
+				inscriptive[name] = reason
+			}
+		}
+		s.Log.Info("analyzed label history",
+			"repo", addr.User+"/"+addr.Repo,
+			"events", len(events),
+			"inscriptive", inscriptive,
+		)
+		return inscriptive, nil
+	}, inscriptiveTTL)
+}
@@ -0,0 +1,114 @@
+package labeler
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v59/github"
+)
+
+func TestLabelHistoryInscriptive(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name string
+		h    labelHistory
+		want bool
+	}{
+		{"Inscriptive", labelHistory{Adds: 10, Late: 8, ByOthers: 10, Association: 0.1}, true},
+		{"TooFewAdds", labelHistory{Adds: 4, Late: 4, ByOthers: 4}, false},
+		{"Early", labelHistory{Adds: 10, Late: 4, ByOthers: 10}, false},
+		{"ByAuthors", labelHistory{Adds: 10, Late: 10, ByOthers: 7}, false},
+		{"ContentDriven", labelHistory{Adds: 10, Late: 10, ByOthers: 10, Association: 0.5}, false},
+		{"AtThresholds", labelHistory{Adds: 5, Late: 3, ByOthers: 4, Association: 0.49}, true},
+	} {
+		reason, got := tt.h.inscriptive()
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		if got && reason == "" {
+			t.Errorf("%s: got no reason", tt.name)
+		}
+	}
+}
+
+func TestLabelHistories(t *testing.T) {
+	t.Parallel()
+	opened := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	issues := make(map[int]*github.Issue)
+	for n := 1; n <= 10; n++ {
+		title := "crash when saving the file"
+		if n > 6 {
+			title = "feature idea for the dashboard"
+		}
+		issues[n] = &github.Issue{
+			Number:    github.Int(n),
+			Title:     github.String(title),
+			Body:      github.String("version " + string(rune('a'+n)) + "xx"),
+			User:      &github.User{Login: github.String("author")},
+			CreatedAt: &github.Timestamp{Time: opened},
+		}
+	}
+	event := func(kind string, n int, label, actor, actorType string, after time.Duration) *github.IssueEvent {
+		return &github.IssueEvent{
+			Event:     github.String(kind),
+			Issue:     issues[n],
+			Label:     &github.Label{Name: github.String(label)},
+			Actor:     &github.User{Login: github.String(actor), Type: github.String(actorType)},
+			CreatedAt: &github.Timestamp{Time: opened.Add(after)},
+		}
+	}
+
+	var events []*github.IssueEvent
+	for n := 1; n <= 10; n++ {
+		// Every issue is seen, labeled or not.
+		events = append(events, event("closed", n, "", "maintainer", "User", time.Hour))
+	}
+	// bug is on most issues and set by maintainers days later, but only
+	// on crashes.
+	for n := 1; n <= 6; n++ {
+		events = append(events, event("labeled", n, "bug", "maintainer", "User", 72*time.Hour))
+	}
+	// wontfix has nothing to do with what the issues say.
+	for _, n := range []int{1, 2, 3, 7, 8} {
+		events = append(events, event("labeled", n, "wontfix", "maintainer", "User", 72*time.Hour))
+	}
+	// needs-triage is set by the author's template, and by a bot.
+	for n := 1; n <= 10; n++ {
+		events = append(events,
+			event("labeled", n, "needs-triage", "author", "User", time.Minute),
+			event("labeled", n, "stale", "github-actions", "Bot", 90*24*time.Hour),
+		)
+	}
+
+	histories := labelHistories(events)
+
+	for _, tt := range []struct {
+		label           string
+		adds, late, by  int
+		association     float64
+		wantInscriptive bool
+	}{
+		{"bug", 6, 6, 6, 1, false},
+		{"wontfix", 5, 5, 5, 0, true},
+		// On every issue, so no word can tell.
+		{"needs-triage", 10, 0, 0, 0, false},
+	} {
+		h := histories[tt.label]
+		if h == nil {
+			t.Fatalf("%s: no history", tt.label)
+		}
+		if h.Adds != tt.adds || h.Late != tt.late || h.ByOthers != tt.by {
+			t.Errorf("%s: got %+v, want %d adds, %d late, %d by others", tt.label, h, tt.adds, tt.late, tt.by)
+		}
+		if math.Abs(h.Association-tt.association) > 1e-9 {
+			t.Errorf("%s: got association %v, want %v", tt.label, h.Association, tt.association)
+		}
+		if _, ok := h.inscriptive(); ok != tt.wantInscriptive {
+			t.Errorf("%s: got inscriptive %v, want %v", tt.label, ok, tt.wantInscriptive)
+		}
+	}
+	if _, ok := histories["stale"]; ok {
+		t.Error("got a history for a label only bots set")
+	}
+}
@@ -0,0 +1,96 @@
+package labeler
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxInstructionsLen caps the instructions, glossary and label guidance
+// together, in bytes, to protect the token budget.
+const maxInstructionsLen = 4000
+
+// injectionPatterns match text that tries to override the bot's own
+// instructions or impersonate the structure of the prompt.
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,40}\b(previous|prior|above|earlier|system|all)\b.{0,20}\b(instructions?|prompts?|rules|messages?)\b`),
+	regexp.MustCompile(`(?i)\byou are (now|no longer)\b`),
+	regexp.MustCompile(`(?im)^\s*(system|assistant|user)\s*:`),
+	regexp.MustCompile(`(?i)<\|?(im_start|im_end|system|endoftext)\|?>`),
+	regexp.MustCompile(`(?m)^=== .* ===$`),
+}
+
+// checkPromptText rejects text that is unsafe to place in a system
+// message.
+func checkPromptText(field, text string) error {
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return fmt.Errorf("%s: contains control character %U", field, r)
+		}
+	}
+	for _, re := range injectionPatterns {
+		if m := re.FindString(text); m != "" {
+			return fmt.Errorf("%s: %q looks like a prompt injection", field, m)
+		}
+	}
+	return nil
+}
+
+// checkInstructions validates the instructions, glossary and label
+// guidance, all of which go into the system messages.
+func (c *repoConfig) checkInstructions() error {
+	size := len(c.Instructions)
+	if err := checkPromptText("instructions", c.Instructions); err != nil {
+		return err
+	}
+	for term, meaning := range c.Glossary {
+		size += len(term) + len(meaning)
+		if err := checkPromptText("glossary."+term, term+"\n"+meaning); err != nil {
+			return err
+		}
+	}
+	labels := make([]string, 0, len(c.Labels))
+	for label := range c.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		guidance := c.Labels[label].Guidance
+		size += len(guidance)
+		if err := checkPromptText("labels."+label+".guidance", guidance); err != nil {
+			return err
+		}
+	}
+	if size > maxInstructionsLen {
+		return fmt.Errorf("instructions, glossary and label guidance are %d bytes, the limit is %d",
+			size, maxInstructionsLen)
+	}
+	return nil
+}
+
+// instructionsText renders the instructions and glossary for the prompt,
+// or returns "" if there are none.
+func (c *repoConfig) instructionsText() string {
+	var sb strings.Builder
+	if s := strings.TrimSpace(c.Instructions); s != "" {
+		sb.WriteString(s)
+		sb.WriteString("\n")
+	}
+	if len(c.Glossary) > 0 {
+		terms := make([]string, 0, len(c.Glossary))
+		for term := range c.Glossary {
+			terms = append(terms, term)
+		}
+		sort.Strings(terms)
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("Glossary of terms used in this repository:\n")
+		for _, term := range terms {
+			fmt.Fprintf(&sb, "- %s: %s\n", term, strings.Join(strings.Fields(c.Glossary[term]), " "))
+		}
+	}
+	return sb.String()
+}
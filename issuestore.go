@@ -0,0 +1,466 @@
+package labeler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// IssueStore holds the Indexer's embedded issues. Issues are only ever
+// inserted; a newer row of an issue supersedes the older ones.
+type IssueStore interface {
+	PutIssue(ctx context.Context, issue BqIssue) error
+	// UpdatedAts returns the last indexed UpdatedAt of each of the
+	// installation's issues, by issue ID.
+	UpdatedAts(ctx context.Context, installID int64) (map[int64]time.Time, error)
+	// Nearest returns the issues nearest to the query's embedding by
+	// cosine distance, nearest first.
+	Nearest(ctx context.Context, q NearestQuery) ([]NearestIssue, error)
+	// Search returns the issues, not pull requests, nearest to the query's
+	// embedding across an installation's repos, nearest first.
+	Search(ctx context.Context, q SearchQuery) ([]SearchResult, error)
+}
+
+// NearestQuery searches one repo's issues or pull requests.
+type NearestQuery struct {
+	InstallID   int64
+	User, Repo  string
+	PullRequest bool
+	// Exclude is an issue number left out of the results, e.g. the
+	// target's own.
+	Exclude   int
+	Embedding []float64
+	K         int
+}
+
+// NearestIssue is a result of IssueStore.Nearest.
+type NearestIssue struct {
+	Number int `bigquery:"number"`
+	// Distance is the cosine distance, from 0 for the same direction to 2
+	// for the opposite.
+	Distance float64 `bigquery:"distance"`
+}
+
+// SearchQuery searches an installation's issues.
+type SearchQuery struct {
+	InstallID int64
+	// Repos, as owner/repo, are the repos searched. If empty, none are.
+	Repos []string
+	// ExcludeID is an issue ID left out of the results.
+	ExcludeID int64
+	Embedding []float64
+	K         int
+}
+
+// SearchResult is a result of IssueStore.Search, as of its last indexing.
+type SearchResult struct {
+	User     string  `bigquery:"user"`
+	Repo     string  `bigquery:"repo"`
+	Number   int     `bigquery:"number"`
+	Title    string  `bigquery:"title"`
+	State    string  `bigquery:"state"`
+	Distance float64 `bigquery:"distance"`
+}
+
+// issuesTableName is incremented with major schema changes since DML on
+// active tables is very slow.
+const issuesTableName = "issues_v2"
+
+type bigQueryIssueStore struct {
+	client *bigquery.Client
+}
+
+// NewBigQueryIssueStore stores issues in the ghindex dataset.
+func NewBigQueryIssueStore(client *bigquery.Client) IssueStore {
+	return &bigQueryIssueStore{client: client}
+}
+
+func (s *bigQueryIssueStore) PutIssue(ctx context.Context, issue BqIssue) error {
+	return s.client.Dataset("ghindex").Table(issuesTableName).Inserter().Put(ctx, issue)
+}
+
+func (s *bigQueryIssueStore) UpdatedAts(ctx context.Context, installID int64) (map[int64]time.Time, error) {
+	queryStr := `
+	WITH RankedIssues AS (
+	  SELECT
+		id,
+		updated_at,
+		inserted_at,
+		ROW_NUMBER() OVER (PARTITION BY inserted_at, id ORDER BY inserted_at DESC) AS rn
+	  FROM
+		` + "`coder-labeler.ghindex." + issuesTableName + "`" + `
+	  WHERE install_id = @install_id
+	)
+	SELECT
+	  id,
+	  updated_at
+	FROM
+	  RankedIssues
+	WHERE
+	  rn = 1
+	ORDER BY
+	  inserted_at DESC;
+	`
+
+	q := s.client.Query(queryStr)
+	q.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "install_id",
+			Value: installID,
+		},
+	}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("run query: %w", err)
+	}
+	iter, err := job.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read query: %w", err)
+	}
+
+	issues := make(map[int64]time.Time)
+	for {
+		var i BqIssue
+		err := iter.Next(&i)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read issue: %w", err)
+		}
+		issues[i.ID] = i.UpdatedAt
+	}
+	return issues, nil
+}
+
+// Nearest only considers the latest row of each issue, which keeps
+// VECTOR_SEARCH from using the vector index, but a repo is small enough to
+// search exhaustively.
+func (s *bigQueryIssueStore) Nearest(ctx context.Context, nq NearestQuery) ([]NearestIssue, error) {
+	q := s.client.Query(`
+	SELECT base.number AS number, distance
+	FROM VECTOR_SEARCH(
+	  (
+		SELECT number, embedding
+		FROM ` + "`coder-labeler.ghindex." + issuesTableName + "`" + `
+		WHERE install_id = @install_id AND user = @user AND repo = @repo
+		  AND pull_request = @pull_request AND number != @number
+		QUALIFY ROW_NUMBER() OVER (PARTITION BY id ORDER BY inserted_at DESC) = 1
+	  ),
+	  'embedding',
+	  (SELECT @embedding AS embedding),
+	  top_k => @k,
+	  distance_type => 'COSINE'
+	)
+	ORDER BY distance
+	`)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "install_id", Value: nq.InstallID},
+		{Name: "user", Value: nq.User},
+		{Name: "repo", Value: nq.Repo},
+		{Name: "pull_request", Value: nq.PullRequest},
+		{Name: "number", Value: nq.Exclude},
+		{Name: "embedding", Value: nq.Embedding},
+		{Name: "k", Value: nq.K},
+	}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("run query: %w", err)
+	}
+	iter, err := job.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read query: %w", err)
+	}
+
+	var nearest []NearestIssue
+	for {
+		var row NearestIssue
+		err := iter.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read row: %w", err)
+		}
+		nearest = append(nearest, row)
+	}
+	return nearest, nil
+}
+
+func (s *bigQueryIssueStore) Search(ctx context.Context, sq SearchQuery) ([]SearchResult, error) {
+	if len(sq.Repos) == 0 {
+		return nil, nil
+	}
+	repos := make([]string, 0, len(sq.Repos))
+	for _, repo := range sq.Repos {
+		repos = append(repos, strings.ToLower(repo))
+	}
+
+	q := s.client.Query(`
+	SELECT base.user AS user, base.repo AS repo, base.number AS number,
+	  base.title AS title, base.state AS state, distance
+	FROM VECTOR_SEARCH(
+	  (
+		SELECT user, repo, number, title, state, embedding
+		FROM ` + "`coder-labeler.ghindex." + issuesTableName + "`" + `
+		WHERE install_id = @install_id AND NOT pull_request AND id != @exclude_id
+		  AND LOWER(CONCAT(user, '/', repo)) IN UNNEST(@repos)
+		QUALIFY ROW_NUMBER() OVER (PARTITION BY id ORDER BY inserted_at DESC) = 1
+	  ),
+	  'embedding',
+	  (SELECT @embedding AS embedding),
+	  top_k => @k,
+	  distance_type => 'COSINE'
+	)
+	ORDER BY distance
+	`)
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "install_id", Value: sq.InstallID},
+		{Name: "exclude_id", Value: sq.ExcludeID},
+		{Name: "repos", Value: repos},
+		{Name: "embedding", Value: sq.Embedding},
+		{Name: "k", Value: sq.K},
+	}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("run query: %w", err)
+	}
+	iter, err := job.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read query: %w", err)
+	}
+
+	var results []SearchResult
+	for {
+		var row SearchResult
+		err := iter.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read row: %w", err)
+		}
+		results = append(results, row)
+	}
+	return results, nil
+}
+
+// issueSchema holds the latest row of every issue. Embeddings are packed
+// by encodeEmbedding.
+const issueSchema = `
+CREATE TABLE IF NOT EXISTS issues (
+	id           INTEGER PRIMARY KEY,
+	install_id   INTEGER NOT NULL,
+	user         TEXT NOT NULL,
+	repo         TEXT NOT NULL,
+	number       INTEGER NOT NULL,
+	title        TEXT NOT NULL,
+	state        TEXT NOT NULL,
+	body         TEXT NOT NULL,
+	created_at   TIMESTAMP NOT NULL,
+	updated_at   TIMESTAMP NOT NULL,
+	inserted_at  TIMESTAMP NOT NULL,
+	embedding    BLOB NOT NULL,
+	pull_request INTEGER NOT NULL
+);
+`
+
+// sqliteIssueStore keeps the latest row of every issue in SQLite, and a
+// copy in memory that it searches exhaustively, which is fine up to a few
+// hundred thousand issues. Superseded rows are replaced, so the database
+// only grows with new issues.
+type sqliteIssueStore struct {
+	db *sql.DB
+
+	mu     sync.RWMutex
+	issues map[int64]BqIssue
+}
+
+// NewSQLiteIssueStore stores issues in a SQLite database in dir.
+func NewSQLiteIssueStore(dir string) (IssueStore, error) {
+	db, err := openSQLite(filepath.Join(dir, "issues.db"), issueSchema)
+	if err != nil {
+		return nil, err
+	}
+	s := &sqliteIssueStore{
+		db:     db,
+		issues: make(map[int64]BqIssue),
+	}
+	err = s.load()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("load issues: %w", err)
+	}
+	return s, nil
+}
+
+func (s *sqliteIssueStore) load() error {
+	rows, err := s.db.Query(`
+	SELECT id, install_id, user, repo, number, title, state, body,
+		created_at, updated_at, inserted_at, embedding, pull_request
+	FROM issues
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			issue     BqIssue
+			embedding []byte
+		)
+		err := rows.Scan(&issue.ID, &issue.InstallID, &issue.User, &issue.Repo,
+			&issue.Number, &issue.Title, &issue.State, &issue.Body,
+			&issue.CreatedAt, &issue.UpdatedAt, &issue.InsertedAt,
+			&embedding, &issue.PullRequest,
+		)
+		if err != nil {
+			return err
+		}
+		issue.Embedding, err = decodeEmbedding(embedding)
+		if err != nil {
+			return fmt.Errorf("issue %d: %w", issue.ID, err)
+		}
+		s.put(issue)
+	}
+	return rows.Err()
+}
+
+func (s *sqliteIssueStore) put(issue BqIssue) {
+	if old, ok := s.issues[issue.ID]; ok && old.InsertedAt.After(issue.InsertedAt) {
+		return
+	}
+	s.issues[issue.ID] = issue
+}
+
+func (s *sqliteIssueStore) PutIssue(ctx context.Context, issue BqIssue) error {
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO issues (id, install_id, user, repo, number, title, state, body,
+		created_at, updated_at, inserted_at, embedding, pull_request)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET
+		install_id = excluded.install_id,
+		user = excluded.user,
+		repo = excluded.repo,
+		number = excluded.number,
+		title = excluded.title,
+		state = excluded.state,
+		body = excluded.body,
+		created_at = excluded.created_at,
+		updated_at = excluded.updated_at,
+		inserted_at = excluded.inserted_at,
+		embedding = excluded.embedding,
+		pull_request = excluded.pull_request
+	WHERE excluded.inserted_at >= issues.inserted_at
+	`, issue.ID, issue.InstallID, issue.User, issue.Repo, issue.Number,
+		issue.Title, issue.State, issue.Body,
+		issue.CreatedAt.UTC(), issue.UpdatedAt.UTC(), issue.InsertedAt.UTC(),
+		encodeEmbedding(issue.Embedding), issue.PullRequest,
+	)
+	if err != nil {
+		return fmt.Errorf("insert issue: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(issue)
+	return nil
+}
+
+func (s *sqliteIssueStore) UpdatedAts(_ context.Context, installID int64) (map[int64]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	updatedAts := make(map[int64]time.Time)
+	for id, issue := range s.issues {
+		if issue.InstallID == installID {
+			updatedAts[id] = issue.UpdatedAt
+		}
+	}
+	return updatedAts, nil
+}
+
+func (s *sqliteIssueStore) Nearest(_ context.Context, q NearestQuery) ([]NearestIssue, error) {
+	var nearest []NearestIssue
+
+	s.mu.RLock()
+	for _, issue := range s.issues {
+		if issue.InstallID != q.InstallID || issue.PullRequest != q.PullRequest ||
+			issue.Number == q.Exclude ||
+			!strings.EqualFold(issue.User, q.User) || !strings.EqualFold(issue.Repo, q.Repo) {
+			continue
+		}
+		nearest = append(nearest, NearestIssue{
+			Number:   issue.Number,
+			Distance: cosineDistance(q.Embedding, issue.Embedding),
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(nearest, func(i, j int) bool {
+		return nearest[i].Distance < nearest[j].Distance
+	})
+	return nearest[:min(q.K, len(nearest))], nil
+}
+
+func (s *sqliteIssueStore) Search(_ context.Context, q SearchQuery) ([]SearchResult, error) {
+	repos := make(map[string]bool, len(q.Repos))
+	for _, repo := range q.Repos {
+		repos[strings.ToLower(repo)] = true
+	}
+
+	var results []SearchResult
+
+	s.mu.RLock()
+	for id, issue := range s.issues {
+		if issue.InstallID != q.InstallID || issue.PullRequest || id == q.ExcludeID {
+			continue
+		}
+		if !repos[strings.ToLower(issue.User+"/"+issue.Repo)] {
+			continue
+		}
+		results = append(results, SearchResult{
+			User:     issue.User,
+			Repo:     issue.Repo,
+			Number:   issue.Number,
+			Title:    issue.Title,
+			State:    issue.State,
+			Distance: cosineDistance(q.Embedding, issue.Embedding),
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	})
+	return results[:min(q.K, len(results))], nil
+}
+
+// cosineDistance is 1 minus the cosine similarity of a and b, or 1 if
+// either is zero or their lengths differ.
+func cosineDistance(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 1
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/math.Sqrt(normA*normB)
+}
@@ -0,0 +1,131 @@
+package labeler
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCosineDistance(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{"Same", []float64{1, 2, 3}, []float64{1, 2, 3}, 0},
+		{"Scaled", []float64{1, 2, 3}, []float64{2, 4, 6}, 0},
+		{"Orthogonal", []float64{1, 0}, []float64{0, 1}, 1},
+		{"Opposite", []float64{1, 1}, []float64{-1, -1}, 2},
+		{"Zero", []float64{0, 0}, []float64{1, 1}, 1},
+		{"LengthMismatch", []float64{1, 0}, []float64{1, 0, 0}, 1},
+		{"Empty", nil, nil, 1},
+	} {
+		if got := cosineDistance(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteIssueStore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewSQLiteIssueStore(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, issue := range []BqIssue{
+		{ID: 1, InstallID: 7, User: "coder", Repo: "coder", Number: 1, Title: "stale", Embedding: []float64{0, 1}, UpdatedAt: now, InsertedAt: now},
+		// Supersedes the first row.
+		{ID: 1, InstallID: 7, User: "coder", Repo: "coder", Number: 1, Title: "crash", State: "open", Embedding: []float64{1, 0}, UpdatedAt: now.Add(time.Hour), InsertedAt: now.Add(time.Hour)},
+		// Older than the row it would replace.
+		{ID: 1, InstallID: 7, User: "coder", Repo: "coder", Number: 1, Title: "older", Embedding: []float64{0, 1}, UpdatedAt: now, InsertedAt: now.Add(-time.Hour)},
+		{ID: 2, InstallID: 7, User: "coder", Repo: "coder", Number: 2, Title: "hang", Embedding: []float64{1, 1}, UpdatedAt: now, InsertedAt: now},
+		{ID: 3, InstallID: 7, User: "coder", Repo: "coder", Number: 3, Title: "fix crash", Embedding: []float64{1, 0}, PullRequest: true, UpdatedAt: now, InsertedAt: now},
+		{ID: 4, InstallID: 7, User: "coder", Repo: "vscode-coder", Number: 1, Title: "crash in vscode", Embedding: []float64{1, 0.1}, UpdatedAt: now, InsertedAt: now},
+		{ID: 5, InstallID: 8, User: "other", Repo: "repo", Number: 1, Title: "other crash", Embedding: []float64{1, 0}, UpdatedAt: now, InsertedAt: now},
+	} {
+		if err := store.PutIssue(ctx, issue); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+
+	check := func(store IssueStore) {
+		t.Helper()
+		updatedAts, err := store.UpdatedAts(ctx, 7)
+		if err != nil {
+			t.Fatalf("updated ats: %v", err)
+		}
+		if len(updatedAts) != 4 || !updatedAts[1].Equal(now.Add(time.Hour)) {
+			t.Fatalf("got updated ats %v, want 4 with issue 1 at %v", updatedAts, now.Add(time.Hour))
+		}
+
+		nearest, err := store.Nearest(ctx, NearestQuery{
+			InstallID: 7, User: "Coder", Repo: "coder",
+			Embedding: []float64{1, 0}, K: 5,
+		})
+		if err != nil {
+			t.Fatalf("nearest: %v", err)
+		}
+		if len(nearest) != 2 || nearest[0].Number != 1 || nearest[1].Number != 2 {
+			t.Fatalf("got nearest %+v, want issues 1 and 2", nearest)
+		}
+
+		results, err := store.Search(ctx, SearchQuery{
+			InstallID: 7, ExcludeID: 2,
+			Repos:     []string{"coder/coder", "coder/vscode-coder", "other/repo"},
+			Embedding: []float64{1, 0}, K: 5,
+		})
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if len(results) != 2 || results[0].Title != "crash" || results[0].State != "open" ||
+			results[1].Repo != "vscode-coder" {
+			t.Fatalf("got results %+v, want coder#1 then vscode-coder#1", results)
+		}
+
+		results, err = store.Search(ctx, SearchQuery{
+			InstallID: 7, Repos: []string{"Coder/VSCode-Coder"},
+			Embedding: []float64{1, 0}, K: 5,
+		})
+		if err != nil {
+			t.Fatalf("search repo: %v", err)
+		}
+		if len(results) != 1 || results[0].Repo != "vscode-coder" {
+			t.Fatalf("got results %+v, want vscode-coder#1", results)
+		}
+
+		// No repos means nothing is readable, not everything.
+		results, err = store.Search(ctx, SearchQuery{
+			InstallID: 7, Embedding: []float64{1, 0}, K: 5,
+		})
+		if err != nil {
+			t.Fatalf("search no repos: %v", err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("got results %+v, want none", results)
+		}
+	}
+	check(store)
+
+	// The store is reloaded on open.
+	store, err = NewSQLiteIssueStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check(store)
+
+	// Superseded rows were replaced, not kept.
+	var rows int
+	err = store.(*sqliteIssueStore).db.QueryRow("SELECT COUNT(*) FROM issues").Scan(&rows)
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if rows != 5 {
+		t.Fatalf("got %d rows, want 5", rows)
+	}
+}
@@ -0,0 +1,262 @@
+package labeler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/coder/labeler/httpjson"
+	"github.com/coder/labeler/queue"
+	"github.com/google/go-github/v59/github"
+)
+
+// jobTimeout bounds a single attempt at a job. It is much longer than
+// GitHub's 10 second webhook timeout since jobs normally run outside of
+// the request.
+const jobTimeout = 5 * time.Minute
+
+const jobKindLabel = "label"
+
+// labelJob infers and sets labels on an opened or edited issue or pull
+// request.
+type labelJob struct {
+	InstallID string `json:"install_id"`
+	User      string `json:"user"`
+	Repo      string `json:"repo"`
+	Issue     int    `json:"issue"`
+	URL       string `json:"url"`
+
+	// Edited is set when the job comes from an edit of the issue, in
+	// which case Change is the fraction of the title and body that
+	// changed.
+	Edited bool    `json:"edited,omitempty"`
+	Change float64 `json:"change,omitempty"`
+	// Opened is set when the job comes from the issue or pull request
+	// being opened, rather than edited or pushed to.
+	Opened bool `json:"opened,omitempty"`
+
+	PullRequest bool `json:"pull_request,omitempty"`
+	// HeadSHA is the head commit of a pull request.
+	HeadSHA string `json:"head_sha,omitempty"`
+}
+
+// dispatch acknowledges a webhook delivery by queueing its job, or, when
+// no queue is configured, runs the job within the request.
+func (s *Webhook) dispatch(r *http.Request, kind string, payload any) *httpjson.Response {
+	if s.Queue == nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return s.serverError(err)
+		}
+		result, err := s.runJob(r.Context(), &queue.Job{Kind: kind, Payload: data})
+		if err != nil {
+			return s.serverError(err)
+		}
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   result,
+		}
+	}
+
+	delivery := r.Header.Get("X-GitHub-Delivery")
+	job, err := s.Queue.Enqueue(delivery, kind, payload)
+	if errors.Is(err, queue.ErrDuplicate) {
+		// Still pending, so it's accepted like the first delivery and
+		// finished by the job rather than marked processed now.
+		return &httpjson.Response{
+			Status: http.StatusAccepted,
+			Body:   httpjson.M{"message": "already queued", "job": delivery},
+		}
+	}
+	if err != nil {
+		return s.serverError(fmt.Errorf("enqueue: %w", err))
+	}
+
+	return &httpjson.Response{
+		Status: http.StatusAccepted,
+		Body:   httpjson.M{"message": "queued", "job": job.ID},
+	}
+}
+
+func (s *Webhook) runJob(ctx context.Context, job *queue.Job) (httpjson.M, error) {
+	switch job.Kind {
+	case jobKindLabel:
+		var lj labelJob
+		if err := job.Decode(&lj); err != nil {
+			return nil, queue.Permanent(fmt.Errorf("decode %s job: %w", job.Kind, err))
+		}
+		return s.labelIssue(ctx, lj)
+	case jobKindCommand:
+		var cj commandJob
+		if err := job.Decode(&cj); err != nil {
+			return nil, queue.Permanent(fmt.Errorf("decode %s job: %w", job.Kind, err))
+		}
+		return s.runCommand(ctx, cj)
+	default:
+		return nil, queue.Permanent(fmt.Errorf("unknown job kind %q", job.Kind))
+	}
+}
+
+func (s *Webhook) handleJob(ctx context.Context, job *queue.Job) error {
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	result, err := s.runJob(ctx, job)
+	if err != nil {
+		if job.Attempts+1 >= s.Queue.MaxAttempts || queue.IsPermanent(err) {
+			// About to be dead-lettered, so let a redelivery retry it.
+			s.finishDelivery(job.ID, false)
+		}
+		return err
+	}
+	s.finishDelivery(job.ID, true)
+	s.Log.Debug("job done",
+		"id", job.ID,
+		"kind", job.Kind,
+		"attempts", job.Attempts+1,
+		"result", result,
+	)
+	return nil
+}
+
+// RunWorkers processes queued webhook jobs until ctx is done.
+func (s *Webhook) RunWorkers(ctx context.Context, workers int) error {
+	if s.Queue == nil {
+		return errors.New("no queue configured")
+	}
+	return s.Queue.Run(ctx, workers, s.handleJob)
+}
+
+func (s *Webhook) labelIssue(ctx context.Context, job labelJob) (httpjson.M, error) {
+	instConfig, err := s.AppConfig.InstallationConfig(job.InstallID)
+	if err != nil {
+		return nil, err
+	}
+
+	githubClient := github.NewClient(instConfig.Client(ctx))
+
+	log := s.Log.With(
+		"install_id", job.InstallID,
+		"user", job.User,
+		"repo", job.Repo,
+		"issue_num", job.Issue,
+		"issue_url", job.URL,
+	)
+
+	if job.PullRequest && job.HeadSHA != "" {
+		// Independent of labeling, so it doesn't fail the job.
+		err := s.checkConfigChange(ctx, githubClient, job)
+		if err != nil {
+			log.Error("check config change", "error", err)
+		}
+	}
+
+	if job.Opened && !job.PullRequest {
+		// Also independent of labeling.
+		err := s.checkDuplicates(ctx, githubClient, job)
+		if err != nil {
+			log.Error("check duplicates", "error", err)
+		}
+	}
+
+	if job.Edited || job.PullRequest {
+		config, err := s.getRepoConfig(ctx, githubClient, job.User, job.Repo)
+		if err != nil {
+			return nil, fmt.Errorf("get repo config: %w", err)
+		}
+		if job.PullRequest && !config.PullRequests.Enabled {
+			return httpjson.M{"message": "pull request labeling is disabled"}, nil
+		}
+		if job.Edited && !config.Edited.Enabled {
+			return httpjson.M{"message": "re-inference on edit is disabled"}, nil
+		}
+		if job.Edited && job.Change < config.Edited.minChange() {
+			log.Debug("ignoring small edit", "change", job.Change)
+			return httpjson.M{"message": "edit too small", "change": job.Change}, nil
+		}
+	}
+
+	resp, err := s.Infer(ctx, &InferRequest{
+		InstallID: job.InstallID,
+		User:      job.User,
+		Repo:      job.Repo,
+		Issue:     job.Issue,
+		applying:  true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("infer: %w, issue: %+v", err, job.URL)
+	}
+	// Recorded however the job ends, with whatever was applied.
+	defer s.recordDecision(ctx, resp.audit)
+
+	// Only add labels the issue doesn't already have, so an edited
+	// issue doesn't report labels that were set the first time around.
+	newLabels := filterSlice(resp.SetLabels, func(label string) bool {
+		return !slices.Contains(resp.existingLabels, label)
+	})
+	if job.Edited || (job.PullRequest && !job.Opened) {
+		// Relabeling mustn't undo triage: a label a maintainer took off
+		// stays off.
+		removed, err := s.removedLabels(ctx, githubClient, job.User, job.Repo, job.Issue)
+		if err != nil {
+			return nil, err
+		}
+		newLabels = filterSlice(newLabels, func(label string) bool {
+			return !slices.Contains(removed, label)
+		})
+	}
+	if len(newLabels) == 0 && len(resp.RemoveLabels) == 0 {
+		// Labels humans add from here on are misses.
+		s.recordApplication(ctx, log, job, nil, resp.DisabledLabels)
+		return httpjson.M{"message": "no labels to set"}, nil
+	}
+
+	// Set the labels.
+	if len(newLabels) > 0 {
+		_, _, err = githubClient.Issues.AddLabelsToIssue(
+			ctx,
+			job.User,
+			job.Repo,
+			job.Issue,
+			newLabels,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("set %v: %w", newLabels, err)
+		}
+		resp.audit.Applied = newLabels
+	}
+
+	// Remove the labels they replace, after adding so the issue is never
+	// left without a member of the group.
+	for _, label := range resp.RemoveLabels {
+		_, err := githubClient.Issues.RemoveLabelForIssue(ctx, job.User, job.Repo, job.Issue, label)
+		if err != nil {
+			var githubErr *github.ErrorResponse
+			if errors.As(err, &githubErr) && githubErr.Response.StatusCode == http.StatusNotFound {
+				// Already removed.
+				continue
+			}
+			return nil, fmt.Errorf("remove %q: %w", label, err)
+		}
+		resp.audit.Removed = append(resp.audit.Removed, label)
+	}
+
+	s.recordApplication(ctx, log, job, newLabels, resp.DisabledLabels)
+
+	log.Info("labels set",
+		"labels", newLabels,
+		"removed", resp.RemoveLabels,
+		"tokens_used", resp.TokensUsed,
+		"edited", job.Edited,
+	)
+
+	return httpjson.M{
+		"message": "labels set",
+		"labels":  newLabels,
+		"removed": resp.RemoveLabels,
+	}, nil
+}
@@ -0,0 +1,33 @@
+package labeler
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coder/labeler/httpjson"
+	"github.com/coder/labeler/queue"
+)
+
+// A redelivery of a job that's still pending is accepted, not processed,
+// so the delivery stays unfinished until the job runs.
+func TestDispatchPendingRedelivery(t *testing.T) {
+	t.Parallel()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	q, err := queue.Open(log, t.TempDir())
+	if err != nil {
+		t.Fatalf("open queue: %v", err)
+	}
+	s := &Webhook{Log: log, Queue: q}
+
+	for _, want := range []string{"queued", "already queued"} {
+		r := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+		r.Header.Set("X-GitHub-Delivery", "delivery-1")
+		resp := s.dispatch(r, jobKindLabel, labelJob{Issue: 1})
+		if resp.Status != http.StatusAccepted || resp.Body.(httpjson.M)["message"] != want {
+			t.Fatalf("got %d %v, want %d %q", resp.Status, resp.Body, http.StatusAccepted, want)
+		}
+	}
+}
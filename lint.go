@@ -0,0 +1,410 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/coder/labeler/ghapi"
+	"github.com/coder/labeler/httpjson"
+	"github.com/google/go-github/v59/github"
+	"gopkg.in/yaml.v3"
+)
+
+// Severities of a LintProblem.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintProblem is a problem found in a labeler config.
+type LintProblem struct {
+	// Line is 1-based, or 0 if unknown.
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (p LintProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%d: %s: %s", p.Line, p.Severity, p.Message)
+	}
+	return p.Severity + ": " + p.Message
+}
+
+// LintHasErrors reports whether any of problems is an error rather than
+// a warning.
+func LintHasErrors(problems []LintProblem) bool {
+	return slices.ContainsFunc(problems, func(p LintProblem) bool {
+		return p.Severity == LintError
+	})
+}
+
+var yamlErrorLineRe = regexp.MustCompile(`line (\d+)`)
+
+func yamlErrorLine(msg string) int {
+	m := yamlErrorLineRe.FindStringSubmatch(msg)
+	if m == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line
+}
+
+// LintConfig checks a labeler config: its syntax, unknown keys, regular
+// expressions and settings. If labels is not nil, every label the config
+// refers to must be one of them.
+func LintConfig(content []byte, labels []string) []LintProblem {
+	var doc yaml.Node
+	err := yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return []LintProblem{{
+			Line:     yamlErrorLine(err.Error()),
+			Severity: LintError,
+			Message:  err.Error(),
+		}}
+	}
+	if doc.Kind == 0 {
+		// Empty.
+		return nil
+	}
+
+	var (
+		config   repoConfig
+		problems []LintProblem
+	)
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	err = dec.Decode(&config)
+	if err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return []LintProblem{{Severity: LintError, Message: err.Error()}}
+		}
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, LintProblem{
+				Line:     yamlErrorLine(msg),
+				Severity: LintError,
+				Message:  msg,
+			})
+		}
+		return problems
+	}
+	err = config.init()
+	if err != nil {
+		problems = append(problems, LintProblem{Severity: LintError, Message: err.Error()})
+	}
+
+	if labels != nil && len(doc.Content) == 1 {
+		problems = append(problems, lintLabelRefs(doc.Content[0], labels)...)
+	}
+	return problems
+}
+
+// lintLabelRefs checks that the label names in the config exist and that
+// its label patterns match at least one label.
+func lintLabelRefs(root *yaml.Node, labels []string) []LintProblem {
+	var problems []LintProblem
+	get := func(n *yaml.Node, key string) *yaml.Node {
+		if n == nil || n.Kind != yaml.MappingNode {
+			return nil
+		}
+		i := mappingIndex(n.Content, key)
+		if i < 0 {
+			return nil
+		}
+		return n.Content[i+1]
+	}
+	items := func(n *yaml.Node) []*yaml.Node {
+		if n == nil || n.Kind != yaml.SequenceNode {
+			return nil
+		}
+		return n.Content
+	}
+	name := func(where string, n *yaml.Node) {
+		if n.Kind != yaml.ScalarNode || slices.Contains(labels, n.Value) {
+			return
+		}
+		problems = append(problems, LintProblem{
+			Line:     n.Line,
+			Severity: LintError,
+			Message:  fmt.Sprintf("%s: label %q doesn't exist", where, n.Value),
+		})
+	}
+	pattern := func(where string, n *yaml.Node, expr string, anchored bool) {
+		if n.Kind != yaml.ScalarNode {
+			return
+		}
+		if anchored {
+			expr = "^(?:" + expr + ")$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			// Reported when decoding.
+			return
+		}
+		if slices.ContainsFunc(labels, re.MatchString) {
+			return
+		}
+		problems = append(problems, LintProblem{
+			Line:     n.Line,
+			Severity: LintWarning,
+			Message:  fmt.Sprintf("%s: %q matches no labels", where, n.Value),
+		})
+	}
+
+	for _, key := range []string{"include", "exclude"} {
+		for _, n := range items(get(root, key)) {
+			pattern(key, n, n.Value, false)
+		}
+	}
+	prs := get(root, "pull_requests")
+	for _, key := range []string{"labels", "only"} {
+		for _, n := range items(get(prs, key)) {
+			pattern("pull_requests."+key, n, n.Value, false)
+		}
+	}
+
+	if policies := get(root, "labels"); policies != nil && policies.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(policies.Content); i += 2 {
+			key := policies.Content[i]
+			if expr, ok := labelPatternKey(key.Value); ok {
+				pattern("labels", key, expr, true)
+			} else {
+				name("labels", key)
+			}
+		}
+	}
+
+	for i, group := range items(get(root, "exclusive")) {
+		where := fmt.Sprintf("exclusive[%d]", i)
+		for _, n := range items(get(group, "labels")) {
+			name(where+".labels", n)
+		}
+		if n := get(group, "match"); n != nil {
+			pattern(where+".match", n, n.Value, false)
+		}
+	}
+
+	for _, key := range []string{"implies", "conflicts_with"} {
+		rules := get(root, key)
+		if rules == nil || rules.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(rules.Content); i += 2 {
+			name(key, rules.Content[i])
+			for _, n := range items(rules.Content[i+1]) {
+				name(key+"."+rules.Content[i].Value, n)
+			}
+		}
+	}
+
+	for i, rule := range items(get(root, "rules")) {
+		for _, n := range items(get(rule, "labels")) {
+			name(fmt.Sprintf("rules[%d].labels", i), n)
+		}
+	}
+
+	for i, group := range items(get(root, "requires_one_of")) {
+		for _, n := range items(group) {
+			name(fmt.Sprintf("requires_one_of[%d]", i), n)
+		}
+	}
+
+	// GitHub creates a missing label when it's added, so this isn't an
+	// error.
+	if n := get(get(root, "duplicates"), "label"); n != nil && n.Kind == yaml.ScalarNode &&
+		n.Value != "" && !slices.Contains(labels, n.Value) {
+		problems = append(problems, LintProblem{
+			Line:     n.Line,
+			Severity: LintWarning,
+			Message:  fmt.Sprintf("duplicates.label: label %q doesn't exist and will be created", n.Value),
+		})
+	}
+	return problems
+}
+
+// lintRepoLabels returns the names of the repo's labels for LintConfig,
+// or nil for the org's .github repo, whose config applies to many repos.
+func (s *Webhook) lintRepoLabels(ctx context.Context, client *github.Client,
+	addr repoAddr,
+) ([]string, error) {
+	if addr.Repo == orgConfigRepo {
+		return nil, nil
+	}
+	labels, err := s.getRepoLabels(ctx, client, addr)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		names = append(names, label.GetName())
+	}
+	return names, nil
+}
+
+// maxLintBody bounds the config accepted by the lint endpoint.
+const maxLintBody = 1 << 20
+
+// lint serves LintConfig. It lints the request body if there is one, and
+// the repo's current config otherwise.
+func (s *Webhook) lint(w http.ResponseWriter, r *http.Request) *httpjson.Response {
+	addr := repoAddr{
+		InstallID: r.URL.Query().Get("install_id"),
+		User:      r.URL.Query().Get("user"),
+		Repo:      r.URL.Query().Get("repo"),
+	}
+	if addr.InstallID == "" || addr.User == "" || addr.Repo == "" {
+		return &httpjson.Response{
+			Status: http.StatusBadRequest,
+			Body:   httpjson.M{"error": "install_id, user and repo are required"},
+		}
+	}
+
+	instConfig, err := s.AppConfig.InstallationConfig(addr.InstallID)
+	if err != nil {
+		return s.serverError(fmt.Errorf("get installation config: %w", err))
+	}
+	client := github.NewClient(instConfig.Client(r.Context()))
+
+	content, err := io.ReadAll(io.LimitReader(r.Body, maxLintBody))
+	if err != nil {
+		return &httpjson.Response{
+			Status: http.StatusBadRequest,
+			Body:   httpjson.M{"error": "read body: " + err.Error()},
+		}
+	}
+	if len(content) == 0 {
+		current, err := s.getConfigContent(r.Context(), client, addr.User, addr.Repo)
+		if err != nil {
+			return s.serverError(err)
+		}
+		content = []byte(current)
+	}
+
+	labels, err := s.lintRepoLabels(r.Context(), client, addr)
+	if err != nil {
+		return s.serverError(err)
+	}
+	problems := LintConfig(content, labels)
+	return &httpjson.Response{
+		Status: http.StatusOK,
+		Body: httpjson.M{
+			"valid":    !LintHasErrors(problems),
+			"problems": problems,
+		},
+	}
+}
+
+// lintCheckName is the name of the check run on pull requests that
+// change the config.
+const lintCheckName = "labeler config"
+
+// maxCheckAnnotations is GitHub's limit per check run request.
+const maxCheckAnnotations = 50
+
+// checkConfigChange lints the config at the head of a pull request that
+// changes it, and reports the result as a check run.
+func (s *Webhook) checkConfigChange(ctx context.Context, client *github.Client,
+	job labelJob,
+) error {
+	files, err := ghapi.Page(
+		ctx,
+		client,
+		func(ctx context.Context, opt *github.ListOptions) ([]*github.CommitFile, *github.Response, error) {
+			return client.PullRequests.ListFiles(ctx, job.User, job.Repo, job.Issue, opt)
+		},
+		3000,
+	)
+	if err != nil {
+		return fmt.Errorf("list files: %w", err)
+	}
+	changed := slices.ContainsFunc(files, func(f *github.CommitFile) bool {
+		return f.GetFilename() == configPath && f.GetStatus() != "removed"
+	})
+	if !changed {
+		return nil
+	}
+
+	fileContent, _, _, err := client.Repositories.GetContents(
+		ctx, job.User, job.Repo, configPath,
+		&github.RepositoryContentGetOptions{Ref: job.HeadSHA},
+	)
+	if err != nil {
+		return fmt.Errorf("get contents: %w", err)
+	}
+	content, err := fileContent.GetContent()
+	if err != nil {
+		return fmt.Errorf("unmarshal content: %w", err)
+	}
+
+	labels, err := s.lintRepoLabels(ctx, client, repoAddr{
+		InstallID: job.InstallID,
+		User:      job.User,
+		Repo:      job.Repo,
+	})
+	if err != nil {
+		return err
+	}
+	problems := LintConfig([]byte(content), labels)
+
+	conclusion, title := "success", "Config is valid"
+	switch {
+	case LintHasErrors(problems):
+		conclusion, title = "failure", fmt.Sprintf("%d problems found", len(problems))
+	case len(problems) > 0:
+		conclusion, title = "neutral", fmt.Sprintf("%d warnings", len(problems))
+	}
+
+	var (
+		summary     bytes.Buffer
+		annotations []*github.CheckRunAnnotation
+	)
+	for _, p := range problems {
+		fmt.Fprintf(&summary, "- %s\n", p)
+		if p.Line == 0 || len(annotations) == maxCheckAnnotations {
+			continue
+		}
+		level := "failure"
+		if p.Severity == LintWarning {
+			level = "warning"
+		}
+		annotations = append(annotations, &github.CheckRunAnnotation{
+			Path:            github.String(configPath),
+			StartLine:       github.Int(p.Line),
+			EndLine:         github.Int(p.Line),
+			AnnotationLevel: github.String(level),
+			Message:         github.String(p.Message),
+		})
+	}
+	if summary.Len() == 0 {
+		summary.WriteString("No problems found.")
+	}
+
+	_, _, err = client.Checks.CreateCheckRun(ctx, job.User, job.Repo, github.CreateCheckRunOptions{
+		Name:       lintCheckName,
+		HeadSHA:    job.HeadSHA,
+		Status:     github.String("completed"),
+		Conclusion: github.String(conclusion),
+		Output: &github.CheckRunOutput{
+			Title:       github.String(title),
+			Summary:     github.String(summary.String()),
+			Annotations: annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("create check run: %w", err)
+	}
+	s.Log.Info("linted config change",
+		"repo", job.User+"/"+job.Repo,
+		"pr", job.Issue,
+		"conclusion", conclusion,
+	)
+	return nil
+}
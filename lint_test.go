@@ -0,0 +1,119 @@
+package labeler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintConfig(t *testing.T) {
+	t.Parallel()
+	labels := []string{"bug", "docs", "area/ui", "s1", "s2"}
+
+	for _, tt := range []struct {
+		name   string
+		config string
+		labels []string
+		// want are the problems as "line: severity: substring", in order.
+		want []string
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name: "Valid",
+			config: `
+exclude: [docs]
+labels:
+    bug:
+        min_confidence: 0.9
+    /area/.*/:
+        applies_to: issues
+exclusive:
+    - name: severity
+      labels: [s1, s2]
+implies:
+    s1: [bug]
+`,
+			labels: labels,
+		},
+		{
+			name:   "Syntax",
+			config: "exclude: [docs\n",
+			want:   []string{"1: error: yaml: line 1"},
+		},
+		{
+			name:   "UnknownKey",
+			config: "\nexlcude: [docs]\n",
+			want:   []string{"2: error: line 2: field exlcude not found"},
+		},
+		{
+			name:   "BadAppliesTo",
+			config: "labels:\n    bug:\n        applies_to: commits\n",
+			want:   []string{"error: labels.bug.applies_to"},
+		},
+		{
+			name:   "MissingLabel",
+			config: "\nimplies:\n    regression: [bug]\n",
+			labels: labels,
+			want:   []string{`3: error: implies: label "regression"`},
+		},
+		{
+			name:   "MissingLabelInGroup",
+			config: "exclusive:\n    - name: severity\n      labels: [s1, s9]\n",
+			labels: labels,
+			want:   []string{`3: error: exclusive[0].labels: label "s9"`},
+		},
+		{
+			name:   "PolicyNameIsExact",
+			config: "labels:\n    c++:\n        guidance: C++ code.\n",
+			labels: labels,
+			want:   []string{`2: error: labels: label "c++"`},
+		},
+		{
+			name:   "PatternMatchesNothing",
+			config: "labels:\n    /kind/.*/:\n        guidance: A kind.\n",
+			labels: labels,
+			want:   []string{`2: warning: labels: "/kind/.*/" matches no labels`},
+		},
+		{
+			name:   "PatternIsAnchored",
+			config: "labels:\n    /ui/:\n        guidance: UI.\n",
+			labels: labels,
+			want:   []string{`2: warning: labels: "/ui/" matches no labels`},
+		},
+		{
+			name:   "ExcludeMatchesNothing",
+			config: "exclude: [wontfix]\n",
+			labels: labels,
+			want:   []string{`1: warning: exclude: "wontfix"`},
+		},
+		{
+			name:   "NoLabels",
+			config: "implies:\n    regression: [bug]\n",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			problems := LintConfig([]byte(tt.config), tt.labels)
+			if len(problems) != len(tt.want) {
+				t.Fatalf("got problems %v, want %v", problems, tt.want)
+			}
+			for i, p := range problems {
+				if !strings.HasPrefix(p.String(), tt.want[i]) {
+					t.Fatalf("got problem %q, want %q", p, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLintHasErrors(t *testing.T) {
+	t.Parallel()
+	if LintHasErrors([]LintProblem{{Severity: LintWarning}}) {
+		t.Fatal("warnings are not errors")
+	}
+	if !LintHasErrors([]LintProblem{{Severity: LintWarning}, {Severity: LintError}}) {
+		t.Fatal("got no errors, want one")
+	}
+}
@@ -0,0 +1,221 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	anthropicBaseURL = "https://api.anthropic.com"
+	anthropicVersion = "2023-06-01"
+	// anthropicMaxTokens is used when the request doesn't set MaxTokens,
+	// which Anthropic requires.
+	anthropicMaxTokens = 4096
+)
+
+// anthropic speaks Anthropic's Messages API. Structured output is done
+// with a tool the model is forced to call, whose input is the response.
+type anthropic struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func newAnthropic(apiKey, baseURL string) *anthropic {
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	return &anthropic{
+		apiKey:  apiKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  http.DefaultClient,
+	}
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type anthropicRequest struct {
+	Model       string               `json:"model"`
+	MaxTokens   int                  `json:"max_tokens"`
+	System      string               `json:"system,omitempty"`
+	Messages    []anthropicMessage   `json:"messages"`
+	Temperature float32              `json:"temperature"`
+	Tools       []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *anthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Content []struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+func (p *anthropic) do(ctx context.Context, method, path string, body, v any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", p.apiKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{Provider: ProviderAnthropic, StatusCode: resp.StatusCode}
+		var errBody struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if json.Unmarshal(data, &errBody) == nil && errBody.Error.Message != "" {
+			apiErr.Type, apiErr.Message = errBody.Error.Type, errBody.Error.Message
+		} else {
+			apiErr.Message = string(data)
+		}
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *anthropic) Complete(ctx context.Context,
+	req openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	areq := anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	}
+	if areq.MaxTokens == 0 {
+		areq.MaxTokens = anthropicMaxTokens
+	}
+
+	// Anthropic takes a single system prompt apart from the messages.
+	var system []string
+	for _, msg := range req.Messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			system = append(system, msg.Content)
+			continue
+		}
+		areq.Messages = append(areq.Messages, anthropicMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+	areq.System = strings.Join(system, "\n\n")
+
+	var toolName string
+	if rf := req.ResponseFormat; rf != nil && rf.JSONSchema != nil {
+		schema, err := json.Marshal(rf.JSONSchema.Schema)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, fmt.Errorf("marshal schema: %w", err)
+		}
+		toolName = rf.JSONSchema.Name
+		areq.Tools = []anthropicTool{{
+			Name:        toolName,
+			Description: rf.JSONSchema.Description,
+			InputSchema: schema,
+		}}
+		areq.ToolChoice = &anthropicToolChoice{Type: "tool", Name: toolName}
+	}
+
+	var aresp anthropicResponse
+	err := p.do(ctx, http.MethodPost, "/v1/messages", areq, &aresp)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+
+	var content strings.Builder
+	for _, block := range aresp.Content {
+		switch {
+		case toolName != "" && block.Type == "tool_use" && block.Name == toolName:
+			content.Write(block.Input)
+		case toolName == "" && block.Type == "text":
+			content.WriteString(block.Text)
+		}
+	}
+	if toolName != "" && content.Len() == 0 {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("model didn't call %q, stop reason %q", toolName, aresp.StopReason)
+	}
+
+	finish := openai.FinishReasonStop
+	if aresp.StopReason == "max_tokens" {
+		finish = openai.FinishReasonLength
+	}
+	return openai.ChatCompletionResponse{
+		ID:    aresp.ID,
+		Model: aresp.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: content.String(),
+			},
+			FinishReason: finish,
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     aresp.Usage.InputTokens,
+			CompletionTokens: aresp.Usage.OutputTokens,
+			TotalTokens:      aresp.Usage.InputTokens + aresp.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+func (p *anthropic) Embed(context.Context, string, int, []string) ([][]float32, error) {
+	return nil, ErrNoEmbeddings
+}
+
+func (p *anthropic) Check(ctx context.Context) error {
+	var models json.RawMessage
+	err := p.do(ctx, http.MethodGet, "/v1/models", nil, &models)
+	if err != nil {
+		return fmt.Errorf("list models: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package llm abstracts the language model APIs the labeler can use.
+//
+// Requests and responses are go-openai's types, since the prompts are
+// built with them and most providers speak the OpenAI API anyway. Other
+// providers translate them to and from their own API.
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Provider is a chat completion and embedding API.
+type Provider interface {
+	// Complete creates a chat completion. If the request has a JSON schema
+	// response format, the content of the response's choice is JSON
+	// matching it. Log probabilities are only returned by providers that
+	// support them.
+	Complete(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	// Embed returns an embedding of each of input.
+	Embed(ctx context.Context, model string, dimensions int, input []string) ([][]float32, error)
+	// Check verifies that the provider is reachable with our credentials.
+	Check(ctx context.Context) error
+}
+
+// Names of providers, as accepted by New.
+const (
+	ProviderOpenAI           = "openai"
+	ProviderAzure            = "azure"
+	ProviderAnthropic        = "anthropic"
+	ProviderOpenAICompatible = "openai-compatible"
+)
+
+// Providers lists the accepted provider names.
+var Providers = []string{
+	ProviderOpenAI,
+	ProviderAzure,
+	ProviderAnthropic,
+	ProviderOpenAICompatible,
+}
+
+// Config selects and configures a provider.
+type Config struct {
+	Provider string
+	APIKey   string
+	// BaseURL is the API's endpoint. It is required for Azure, where it is
+	// the resource's endpoint, and for OpenAI-compatible APIs, e.g.
+	// http://localhost:11434/v1 for Ollama.
+	BaseURL string
+}
+
+// ErrNoEmbeddings is returned by Embed for providers without an
+// embeddings API.
+var ErrNoEmbeddings = errors.New("provider has no embeddings API")
+
+// New returns the provider described by c.
+func New(c Config) (Provider, error) {
+	switch c.Provider {
+	case ProviderOpenAI, "":
+		if c.APIKey == "" {
+			return nil, errors.New("openai: API key is required")
+		}
+		config := openai.DefaultConfig(c.APIKey)
+		if c.BaseURL != "" {
+			config.BaseURL = c.BaseURL
+		}
+		return newOpenAI(config), nil
+	case ProviderAzure:
+		if c.APIKey == "" || c.BaseURL == "" {
+			return nil, errors.New("azure: API key and base URL are required")
+		}
+		// Deployments are expected to be named after their model.
+		return newOpenAI(openai.DefaultAzureConfig(c.APIKey, c.BaseURL)), nil
+	case ProviderOpenAICompatible:
+		if c.BaseURL == "" {
+			return nil, errors.New("openai-compatible: base URL is required")
+		}
+		// Local servers often need no key.
+		config := openai.DefaultConfig(c.APIKey)
+		config.BaseURL = c.BaseURL
+		return newOpenAI(config), nil
+	case ProviderAnthropic:
+		if c.APIKey == "" {
+			return nil, errors.New("anthropic: API key is required")
+		}
+		return newAnthropic(c.APIKey, c.BaseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q, want one of %v", c.Provider, Providers)
+	}
+}
+
+// Error is an error response from a provider that doesn't speak the
+// OpenAI API.
+type Error struct {
+	Provider   string
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %d %s: %s", e.Provider, e.StatusCode, e.Type, e.Message)
+}
+
+// Retryable reports whether err is a server error or rate limit, which
+// are worth retrying.
+func Retryable(err error) bool {
+	var (
+		status int
+		oaiErr *openai.APIError
+		reqErr *openai.RequestError
+		llmErr *Error
+	)
+	switch {
+	case errors.As(err, &oaiErr):
+		status = oaiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	case errors.As(err, &llmErr):
+		status = llmErr.StatusCode
+	}
+	// Anthropic uses 529 for overload.
+	return status >= 500 || status == 429
+}
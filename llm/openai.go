@@ -0,0 +1,56 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// openAI serves OpenAI, Azure OpenAI and OpenAI-compatible APIs, which
+// differ only in their client config.
+type openAI struct {
+	client *openai.Client
+}
+
+func newOpenAI(config openai.ClientConfig) *openAI {
+	return &openAI{client: openai.NewClientWithConfig(config)}
+}
+
+func (p *openAI) Complete(ctx context.Context,
+	req openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	return p.client.CreateChatCompletion(ctx, req)
+}
+
+func (p *openAI) Embed(ctx context.Context, model string, dimensions int,
+	input []string,
+) ([][]float32, error) {
+	resp, err := p.client.CreateEmbeddings(ctx, &openai.EmbeddingRequestStrings{
+		Model:      openai.EmbeddingModel(model),
+		Input:      input,
+		Dimensions: dimensions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(input) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(input), len(resp.Data))
+	}
+	embeddings := make([][]float32, len(resp.Data))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(embeddings) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	return embeddings, nil
+}
+
+func (p *openAI) Check(ctx context.Context) error {
+	_, err := p.client.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("list models: %w", err)
+	}
+	return nil
+}
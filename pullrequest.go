@@ -0,0 +1,54 @@
+package labeler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/coder/labeler/ghapi"
+	"github.com/google/go-github/v59/github"
+)
+
+// pullRequestContext holds the parts of a pull request that aren't in its
+// issue representation.
+type pullRequestContext struct {
+	files []*github.CommitFile
+	diff  string
+}
+
+func (s *Webhook) getPullRequestContext(ctx context.Context, client *github.Client,
+	owner, repo string, number int,
+) (*pullRequestContext, error) {
+	files, err := ghapi.Page(
+		ctx,
+		client,
+		func(ctx context.Context, opt *github.ListOptions) ([]*github.CommitFile, *github.Response, error) {
+			return client.PullRequests.ListFiles(ctx, owner, repo, number, opt)
+		},
+		// The file list is truncated in the context anyway.
+		300,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list files: %w", err)
+	}
+
+	diff, _, err := client.PullRequests.GetRaw(ctx, owner, repo, number, github.RawOptions{
+		Type: github.Diff,
+	})
+	if err != nil {
+		// GitHub refuses to render very large diffs. The file list is
+		// still a good signal on its own.
+		var githubErr *github.ErrorResponse
+		if !errors.As(err, &githubErr) || githubErr.Response.StatusCode != http.StatusNotAcceptable {
+			return nil, fmt.Errorf("get diff: %w", err)
+		}
+		s.Log.Debug("diff too large", "repo", owner+"/"+repo, "pr", number)
+		diff = ""
+	}
+
+	return &pullRequestContext{
+		files: files,
+		diff:  diff,
+	}, nil
+}
@@ -0,0 +1,369 @@
+// Package queue implements a small durable job queue backed by a
+// directory of JSON files.
+//
+// Each pending job is a file in <dir>/pending. Jobs that exhaust their
+// attempts are moved to <dir>/dead, the dead-letter list. Pending jobs are
+// loaded into memory on Open and the files are only written, so claiming
+// doesn't touch the disk. Claimed jobs are only tracked in memory, so
+// anything that was running when the process died is simply pending again
+// on the next Open.
+//
+// The queue is only as durable as dir: it must be on a persistent volume,
+// not e.g. Cloud Run's in-memory filesystem.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job is a unit of work persisted to disk.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"created_at"`
+	RunAfter  time.Time       `json:"run_after"`
+	LastError string          `json:"last_error,omitempty"`
+}
+
+// Decode unmarshals the job payload into v.
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// ErrDuplicate is returned by Enqueue when a pending job with the same ID
+// already exists.
+var ErrDuplicate = errors.New("job already queued")
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying. The job is dead-lettered
+// immediately.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var perm permanentError
+	return errors.As(err, &perm)
+}
+
+// Queue is a durable job queue. It is safe for concurrent use within a
+// single process. Multiple processes must not share a directory.
+type Queue struct {
+	Log *slog.Logger
+	// MaxAttempts is the number of times a job is tried before it is
+	// dead-lettered.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the exponential delay between
+	// attempts.
+	MinBackoff, MaxBackoff time.Duration
+
+	dir string
+
+	mu sync.Mutex
+	// pending mirrors <dir>/pending.
+	pending map[string]*Job
+	claimed map[string]struct{}
+	wake    chan struct{}
+}
+
+// Open opens or creates the queue in dir.
+func Open(log *slog.Logger, dir string) (*Queue, error) {
+	for _, sub := range []string{"pending", "dead"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0o750)
+		if err != nil {
+			return nil, fmt.Errorf("create %s dir: %w", sub, err)
+		}
+	}
+	q := &Queue{
+		Log:         log,
+		MaxAttempts: 5,
+		MinBackoff:  5 * time.Second,
+		MaxBackoff:  10 * time.Minute,
+		dir:         dir,
+		pending:     make(map[string]*Job),
+		claimed:     make(map[string]struct{}),
+		wake:        make(chan struct{}, 1),
+	}
+	jobs, err := q.list("pending")
+	if err != nil {
+		return nil, fmt.Errorf("load pending jobs: %w", err)
+	}
+	for _, job := range jobs {
+		q.pending[job.ID] = job
+	}
+	return q, nil
+}
+
+var unsafeIDChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (q *Queue) path(sub, id string) string {
+	return filepath.Join(q.dir, sub, id+".json")
+}
+
+// writeFile writes the job atomically so a crash never leaves a
+// half-written job behind.
+func (q *Queue) writeFile(sub string, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(q.dir, sub), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.path(sub, job.ID))
+}
+
+// Enqueue persists a new job. If id is empty, a random one is generated.
+// Otherwise it is used to make enqueueing idempotent: ErrDuplicate is
+// returned if a job with the same ID is still pending.
+func (q *Queue) Enqueue(id, kind string, payload any) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal payload: %w", err)
+	}
+	id = unsafeIDChars.ReplaceAllString(id, "")
+	if id == "" {
+		id = newID()
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		Kind:      kind,
+		Payload:   data,
+		CreatedAt: now,
+		RunAfter:  now,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[id]; ok {
+		return nil, ErrDuplicate
+	}
+	if err := q.writeFile("pending", job); err != nil {
+		return nil, fmt.Errorf("write job: %w", err)
+	}
+	q.pending[id] = job
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+func (q *Queue) list(sub string) ([]*Job, error) {
+	entries, err := os.ReadDir(filepath.Join(q.dir, sub))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(q.dir, sub, e.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Completed by another worker since ReadDir.
+				continue
+			}
+			return nil, err
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			q.Log.Error("skipping corrupt job", "file", e.Name(), "error", err)
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+func sortJobs(jobs []*Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].RunAfter.Before(jobs[j].RunAfter)
+	})
+}
+
+// Pending returns all jobs that have not completed or been dead-lettered.
+func (q *Queue) Pending() ([]*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]*Job, 0, len(q.pending))
+	for _, job := range q.pending {
+		cp := *job
+		jobs = append(jobs, &cp)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// Dead returns the dead-letter list.
+func (q *Queue) Dead() ([]*Job, error) {
+	return q.list("dead")
+}
+
+// claim returns the next runnable job, or nil if there is none. The job
+// is a copy, so the worker may change it freely.
+func (q *Queue) claim() *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var (
+		next *Job
+		now  = time.Now()
+	)
+	for id, job := range q.pending {
+		if _, ok := q.claimed[id]; ok || job.RunAfter.After(now) {
+			continue
+		}
+		if next == nil || job.RunAfter.Before(next.RunAfter) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil
+	}
+	q.claimed[next.ID] = struct{}{}
+	cp := *next
+	return &cp
+}
+
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.MinBackoff
+	for i := 1; i < attempts && d < q.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.MaxBackoff {
+		d = q.MaxBackoff
+	}
+	return d
+}
+
+// finish records the outcome of a claimed job.
+func (q *Queue) finish(job *Job, jobErr error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer delete(q.claimed, job.ID)
+
+	if jobErr == nil {
+		delete(q.pending, job.ID)
+		return os.Remove(q.path("pending", job.ID))
+	}
+
+	job.Attempts++
+	job.LastError = jobErr.Error()
+
+	if IsPermanent(jobErr) || job.Attempts >= q.MaxAttempts {
+		if err := q.writeFile("dead", job); err != nil {
+			return err
+		}
+		q.Log.Error("job dead-lettered",
+			"id", job.ID,
+			"kind", job.Kind,
+			"attempts", job.Attempts,
+			"error", jobErr,
+		)
+		delete(q.pending, job.ID)
+		return os.Remove(q.path("pending", job.ID))
+	}
+
+	job.RunAfter = time.Now().Add(q.backoff(job.Attempts))
+	q.Log.Warn("job failed, will retry",
+		"id", job.ID,
+		"kind", job.Kind,
+		"attempts", job.Attempts,
+		"run_after", job.RunAfter,
+		"error", jobErr,
+	)
+	q.pending[job.ID] = job
+	return q.writeFile("pending", job)
+}
+
+// Run processes jobs with the given number of workers until ctx is done.
+// A job is retried with exponential backoff whenever handle returns an
+// error.
+func (q *Queue) Run(ctx context.Context, workers int, handle func(context.Context, *Job) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				job := q.claim()
+				if job != nil {
+					err := handle(ctx, job)
+					if ctx.Err() != nil {
+						// Shutting down. Leave the job pending without
+						// spending an attempt on it.
+						q.mu.Lock()
+						delete(q.claimed, job.ID)
+						q.mu.Unlock()
+						return
+					}
+					if err := q.finish(job, err); err != nil {
+						q.Log.Error("finish job", "id", job.ID, "error", err)
+					}
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-q.wake:
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,191 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTest(t *testing.T, dir string) *Queue {
+	t.Helper()
+	q, err := Open(slog.New(slog.NewTextHandler(io.Discard, nil)), dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	q.MinBackoff = time.Millisecond
+	q.MaxBackoff = time.Millisecond
+	return q
+}
+
+// runUntil runs q until done returns true or the test times out.
+func runUntil(t *testing.T, q *Queue, handle func(context.Context, *Job) error, done func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- q.Run(ctx, 2, handle)
+	}()
+	for !done() {
+		if ctx.Err() != nil {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-errc
+}
+
+func TestEnqueueDuplicate(t *testing.T) {
+	t.Parallel()
+	q := openTest(t, t.TempDir())
+
+	_, err := q.Enqueue("delivery-1", "label", map[string]int{"issue": 1})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	_, err = q.Enqueue("delivery-1", "label", map[string]int{"issue": 1})
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("second enqueue: got %v, want ErrDuplicate", err)
+	}
+
+	pending, err := q.Pending()
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("got %d pending jobs, want 1", len(pending))
+	}
+}
+
+func TestRunCompletes(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	q := openTest(t, dir)
+
+	job, err := q.Enqueue("", "label", map[string]int{"issue": 7})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	var (
+		mu  sync.Mutex
+		got []int
+	)
+	runUntil(t, q, func(_ context.Context, j *Job) error {
+		var payload struct{ Issue int }
+		if err := j.Decode(&payload); err != nil {
+			return err
+		}
+		mu.Lock()
+		got = append(got, payload.Issue)
+		mu.Unlock()
+		return nil
+	}, func() bool {
+		pending, _ := q.Pending()
+		return len(pending) == 0
+	})
+
+	if len(got) != 1 || got[0] != 7 {
+		t.Fatalf("handled %v, want [7]", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pending", job.ID+".json")); !os.IsNotExist(err) {
+		t.Fatalf("job file still exists: %v", err)
+	}
+}
+
+func TestRunRetriesThenDeadLetters(t *testing.T) {
+	t.Parallel()
+	q := openTest(t, t.TempDir())
+	q.MaxAttempts = 3
+
+	_, err := q.Enqueue("", "label", nil)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	runUntil(t, q, func(context.Context, *Job) error {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		return errors.New("flaky")
+	}, func() bool {
+		dead, _ := q.Dead()
+		return len(dead) == 1
+	})
+
+	if attempts != 3 {
+		t.Fatalf("got %d attempts, want 3", attempts)
+	}
+	dead, _ := q.Dead()
+	if dead[0].LastError != "flaky" {
+		t.Fatalf("got last error %q, want flaky", dead[0].LastError)
+	}
+	pending, _ := q.Pending()
+	if len(pending) != 0 {
+		t.Fatalf("got %d pending jobs, want 0", len(pending))
+	}
+}
+
+func TestRunPermanent(t *testing.T) {
+	t.Parallel()
+	q := openTest(t, t.TempDir())
+
+	_, err := q.Enqueue("", "label", nil)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	runUntil(t, q, func(context.Context, *Job) error {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		return Permanent(errors.New("bad payload"))
+	}, func() bool {
+		dead, _ := q.Dead()
+		return len(dead) == 1
+	})
+
+	if attempts != 1 {
+		t.Fatalf("got %d attempts, want 1", attempts)
+	}
+}
+
+func TestOpenLoadsPending(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	q := openTest(t, dir)
+	_, err := q.Enqueue("delivery-1", "label", nil)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	// A restart.
+	q = openTest(t, dir)
+	pending, err := q.Pending()
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != "delivery-1" {
+		t.Fatalf("got pending %v, want delivery-1", pending)
+	}
+	_, err = q.Enqueue("delivery-1", "label", nil)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("enqueue after reopen: got %v, want ErrDuplicate", err)
+	}
+}
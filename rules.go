@@ -0,0 +1,136 @@
+package labeler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// ruleFiring records a label rule changing the inferred labels.
+type ruleFiring struct {
+	// Rule is implies, conflicts_with or requires_one_of.
+	Rule string `json:"rule"`
+	// Label is the label that was added or dropped.
+	Label  string `json:"label"`
+	Action string `json:"action"`
+	// Because are the labels that triggered the rule.
+	Because []string `json:"because,omitempty"`
+}
+
+const (
+	ruleActionAdded   = "added"
+	ruleActionDropped = "dropped"
+)
+
+// applyImplies adds the labels implied by labels, transitively. Implied
+// labels that aren't allowed are skipped.
+func applyImplies(implies map[string][]string, labels []string,
+	allowed func(string) bool,
+) ([]string, []ruleFiring) {
+	var firings []ruleFiring
+	for i := 0; i < len(labels); i++ {
+		for _, implied := range implies[labels[i]] {
+			if slices.Contains(labels, implied) || !allowed(implied) {
+				continue
+			}
+			labels = append(labels, implied)
+			firings = append(firings, ruleFiring{
+				Rule:    "implies",
+				Label:   implied,
+				Action:  ruleActionAdded,
+				Because: []string{labels[i]},
+			})
+		}
+	}
+	return labels, firings
+}
+
+// applyConflicts drops labels that conflict with each other or with
+// labels the issue already carries. Between two inferred labels, the less
+// confident one is dropped. An existing label always wins.
+func applyConflicts(conflicts map[string][]string, labels []string,
+	confidences map[string]float64, existing []string,
+) ([]string, []ruleFiring) {
+	var (
+		firings []ruleFiring
+		keys    = make([]string, 0, len(conflicts))
+	)
+	for label := range conflicts {
+		keys = append(keys, label)
+	}
+	sort.Strings(keys)
+
+	isNew := func(label string) bool {
+		return slices.Contains(labels, label) && !slices.Contains(existing, label)
+	}
+	drop := func(label, because string) {
+		labels = filterSlice(labels, func(l string) bool { return l != label })
+		firings = append(firings, ruleFiring{
+			Rule:    "conflicts_with",
+			Label:   label,
+			Action:  ruleActionDropped,
+			Because: []string{because},
+		})
+	}
+	for _, a := range keys {
+		for _, b := range conflicts[a] {
+			switch {
+			case isNew(a) && slices.Contains(existing, b):
+				drop(a, b)
+			case isNew(b) && slices.Contains(existing, a):
+				drop(b, a)
+			case isNew(a) && isNew(b):
+				if confidences[b] > confidences[a] {
+					drop(a, b)
+				} else {
+					drop(b, a)
+				}
+			}
+		}
+	}
+	return labels, firings
+}
+
+// missingRequired returns the requires_one_of groups with no member in
+// labels or existing.
+func missingRequired(groups [][]string, labels, existing []string) [][]string {
+	var missing [][]string
+	for _, group := range groups {
+		present := slices.ContainsFunc(group, func(label string) bool {
+			return slices.Contains(labels, label) || slices.Contains(existing, label)
+		})
+		if !present {
+			missing = append(missing, group)
+		}
+	}
+	return missing
+}
+
+// pickLabel re-asks the model to choose one of choices.
+func (s *Webhook) pickLabel(ctx context.Context, aiContext *aiContext,
+	choices []string,
+) (string, int, error) {
+	resp, err := s.complete(ctx, aiContext.PickRequest(s.Model, choices))
+	if err != nil {
+		return "", 0, err
+	}
+	if len(resp.Choices) != 1 {
+		return "", 0, fmt.Errorf("expected one choice")
+	}
+
+	content := resp.Choices[0].Message.Content
+	var pick struct {
+		Reasoning string `json:"reasoning"`
+		Label     string `json:"label"`
+	}
+	err = json.Unmarshal([]byte(content), &pick)
+	if err != nil {
+		return "", 0, fmt.Errorf("unmarshal pickLabel: %w, content: %q", err, content)
+	}
+	if !slices.Contains(choices, pick.Label) {
+		return "", 0, fmt.Errorf("picked %q, not one of %v", pick.Label, choices)
+	}
+	return pick.Label, resp.Usage.TotalTokens, nil
+}
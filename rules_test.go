@@ -0,0 +1,97 @@
+package labeler
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplyImplies(t *testing.T) {
+	t.Parallel()
+	implies := map[string][]string{
+		"area/ui/table": {"area/ui"},
+		"area/ui":       {"frontend", "archived"},
+		"frontend":      {"area/ui"},
+	}
+	allowed := func(label string) bool { return label != "archived" }
+
+	labels, firings := applyImplies(implies, []string{"bug", "area/ui/table"}, allowed)
+	want := []string{"bug", "area/ui/table", "area/ui", "frontend"}
+	if !slices.Equal(labels, want) {
+		t.Fatalf("got labels %v, want %v", labels, want)
+	}
+	if len(firings) != 2 {
+		t.Fatalf("got firings %+v, want 2", firings)
+	}
+	f := firings[1]
+	if f.Label != "frontend" || f.Action != ruleActionAdded || !slices.Equal(f.Because, []string{"area/ui"}) {
+		t.Fatalf("got firing %+v, want frontend added because of area/ui", f)
+	}
+}
+
+func TestApplyConflicts(t *testing.T) {
+	t.Parallel()
+	conflicts := map[string][]string{
+		"bug":     {"feature"},
+		"wontfix": {"good first issue"},
+	}
+
+	for _, tt := range []struct {
+		name        string
+		labels      []string
+		confidences map[string]float64
+		existing    []string
+		want        []string
+		wantDropped []string
+	}{
+		{
+			name:   "NoConflict",
+			labels: []string{"bug", "docs"},
+			want:   []string{"bug", "docs"},
+		},
+		{
+			name:        "LessConfident",
+			labels:      []string{"bug", "feature", "docs"},
+			confidences: map[string]float64{"bug": 0.3, "feature": 0.8},
+			want:        []string{"feature", "docs"},
+			wantDropped: []string{"bug"},
+		},
+		{
+			name:        "Tie",
+			labels:      []string{"bug", "feature"},
+			want:        []string{"bug"},
+			wantDropped: []string{"feature"},
+		},
+		{
+			name:        "ExistingWins",
+			labels:      []string{"good first issue"},
+			existing:    []string{"wontfix"},
+			want:        []string{},
+			wantDropped: []string{"good first issue"},
+		},
+		{
+			name:     "BothExisting",
+			labels:   []string{"bug", "feature"},
+			existing: []string{"bug", "feature"},
+			want:     []string{"bug", "feature"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			labels, firings := applyConflicts(conflicts, tt.labels, tt.confidences, tt.existing)
+			if !slices.Equal(labels, tt.want) {
+				t.Fatalf("got labels %v, want %v", labels, tt.want)
+			}
+			var dropped []string
+			for _, f := range firings {
+				if f.Action != ruleActionDropped {
+					t.Fatalf("got firing %+v, want dropped", f)
+				}
+				dropped = append(dropped, f.Label)
+			}
+			if !slices.Equal(dropped, tt.wantDropped) {
+				t.Fatalf("got dropped %v, want %v", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package labeler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/labeler/ghapi"
+	"github.com/coder/labeler/httpjson"
+	"github.com/google/go-github/v59/github"
+)
+
+const (
+	defaultSearchResults = 10
+	maxSearchResults     = 100
+	// queryEmbeddingTTL is how long a free-text query's embedding is
+	// cached. Embeddings don't change, so it only bounds memory.
+	queryEmbeddingTTL = 24 * time.Hour
+	// accessCheckers bounds concurrent checks of a caller's repo access.
+	accessCheckers = 8
+)
+
+// searchResult is a result of /search.
+type searchResult struct {
+	Repo       string  `json:"repo"`
+	Number     int     `json:"number"`
+	Title      string  `json:"title"`
+	State      string  `json:"state"`
+	URL        string  `json:"url"`
+	Similarity float64 `json:"similarity"`
+}
+
+// issueRefRe matches an issue reference like coder/coder#123.
+var issueRefRe = regexp.MustCompile(`^([\w.-]+)/([\w.-]+)#(\d+)$`)
+
+// readableRepos returns the installation's repos, as owner/repo, that the
+// caller can read. The list is always explicit, so repos that left the
+// installation but are still indexed aren't searched.
+func (s *Webhook) readableRepos(ctx context.Context, p *principal,
+	installID string,
+) ([]string, error) {
+	if p.token != nil {
+		if p.token.InstallID != installID {
+			return nil, nil
+		}
+		if p.token.Repo != "" {
+			// The repo may have since left the installation.
+			user, repo, _ := strings.Cut(p.token.Repo, "/")
+			ok, err := s.auth.canRead(ctx, p, repoAddr{InstallID: installID, User: user, Repo: repo})
+			if err != nil || !ok {
+				return nil, err
+			}
+			return []string{p.token.Repo}, nil
+		}
+	}
+
+	instConfig, err := s.AppConfig.InstallationConfig(installID)
+	if err != nil {
+		return nil, fmt.Errorf("get installation config: %w", err)
+	}
+	client := github.NewClient(instConfig.Client(ctx))
+	installRepos, err := ghapi.Page(ctx,
+		client,
+		func(ctx context.Context, opt *github.ListOptions) ([]*github.Repository, *github.Response, error) {
+			lr, resp, err := client.Apps.ListRepos(ctx, opt)
+			if err != nil {
+				return nil, resp, err
+			}
+			return lr.Repositories, resp, nil
+		},
+		-1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list repos: %w", err)
+	}
+	if p.token != nil {
+		// An installation-wide token reads all of its repos.
+		repos := make([]string, 0, len(installRepos))
+		for _, repo := range installRepos {
+			repos = append(repos, repo.GetFullName())
+		}
+		return repos, nil
+	}
+
+	// Access is cached per repo, so only the first search is slow.
+	var (
+		readable = make([]bool, len(installRepos))
+		errs     = make([]error, len(installRepos))
+		sem      = make(chan struct{}, accessCheckers)
+		wg       sync.WaitGroup
+	)
+	for i, repo := range installRepos {
+		wg.Add(1)
+		go func(i int, repo *github.Repository) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			readable[i], errs[i] = s.auth.canRead(ctx, p, repoAddr{
+				InstallID: installID,
+				User:      repo.GetOwner().GetLogin(),
+				Repo:      repo.GetName(),
+			})
+		}(i, repo)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("check repo access: %w", err)
+	}
+	var repos []string
+	for i, repo := range installRepos {
+		if readable[i] {
+			repos = append(repos, repo.GetFullName())
+		}
+	}
+	return repos, nil
+}
+
+// queryEmbedding embeds a free-text search query, caching it by its
+// words.
+func (s *Webhook) queryEmbedding(ctx context.Context, query string) ([]float64, error) {
+	key := s.EmbeddingModel + "\x00" + strings.Join(strings.Fields(query), " ")
+	return s.queryEmbeddingCache.Do(key, func() ([]float64, error) {
+		return embedText(ctx, s.Embeddings, s.EmbeddingModel, query)
+	}, queryEmbeddingTTL)
+}
+
+func (s *Webhook) search(w http.ResponseWriter, r *http.Request) *httpjson.Response {
+	if s.Issues == nil || s.Embeddings == nil {
+		return &httpjson.Response{
+			Status: http.StatusNotFound,
+			Body:   httpjson.M{"error": "search needs an issue store and embeddings"},
+		}
+	}
+	badRequest := func(msg string) *httpjson.Response {
+		return &httpjson.Response{
+			Status: http.StatusBadRequest,
+			Body:   httpjson.M{"error": msg},
+		}
+	}
+	forbidden := func(msg string) *httpjson.Response {
+		return &httpjson.Response{
+			Status: http.StatusForbidden,
+			Body:   httpjson.M{"error": msg},
+		}
+	}
+
+	var (
+		ctx       = r.Context()
+		params    = r.URL.Query()
+		installID = params.Get("install_id")
+		query     = strings.TrimSpace(params.Get("q"))
+		ref       = params.Get("issue")
+		user      = params.Get("user")
+		repo      = params.Get("repo")
+		limit     = defaultSearchResults
+	)
+	installIDNum, err := strconv.ParseInt(installID, 10, 64)
+	if err != nil {
+		return badRequest("install_id is required")
+	}
+	if (query == "") == (ref == "") {
+		return badRequest("exactly one of q and issue is required")
+	}
+	if (user == "") != (repo == "") {
+		return badRequest("user and repo must be set together")
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxSearchResults {
+			return badRequest(fmt.Sprintf("limit must be between 1 and %d", maxSearchResults))
+		}
+	}
+
+	p := principalFromContext(ctx)
+	if p == nil {
+		return &httpjson.Response{
+			Status: http.StatusUnauthorized,
+			Body:   httpjson.M{"error": "not authenticated"},
+		}
+	}
+
+	var repos []string
+	if repo != "" {
+		addr := repoAddr{InstallID: installID, User: user, Repo: repo}
+		ok, err := s.auth.canRead(ctx, p, addr)
+		if err != nil {
+			return s.serverError(fmt.Errorf("check repo access: %w", err))
+		}
+		if !ok {
+			return forbidden(fmt.Sprintf("no read access to %s/%s", user, repo))
+		}
+		repos = []string{user + "/" + repo}
+	} else {
+		repos, err = s.readableRepos(ctx, p, installID)
+		if err != nil {
+			return s.serverError(err)
+		}
+	}
+
+	sq := SearchQuery{
+		InstallID: installIDNum,
+		Repos:     repos,
+		K:         limit,
+	}
+	if ref != "" {
+		m := issueRefRe.FindStringSubmatch(ref)
+		if m == nil {
+			return badRequest("issue must look like owner/repo#123")
+		}
+		addr := repoAddr{InstallID: installID, User: m[1], Repo: m[2]}
+		number, _ := strconv.Atoi(m[3])
+		ok, err := s.auth.canRead(ctx, p, addr)
+		if err != nil {
+			return s.serverError(fmt.Errorf("check repo access: %w", err))
+		}
+		if !ok {
+			return forbidden(fmt.Sprintf("no read access to %s/%s", addr.User, addr.Repo))
+		}
+
+		instConfig, err := s.AppConfig.InstallationConfig(installID)
+		if err != nil {
+			return s.serverError(fmt.Errorf("get installation config: %w", err))
+		}
+		client := github.NewClient(instConfig.Client(ctx))
+		issue, _, err := client.Issues.Get(ctx, addr.User, addr.Repo, number)
+		if err != nil {
+			var githubErr *github.ErrorResponse
+			if errors.As(err, &githubErr) && githubErr.Response.StatusCode == http.StatusNotFound {
+				return &httpjson.Response{
+					Status: http.StatusNotFound,
+					Body:   httpjson.M{"error": "issue not found: " + ref},
+				}
+			}
+			return s.serverError(fmt.Errorf("get issue: %w", err))
+		}
+		sq.ExcludeID = issue.GetID()
+		sq.Embedding, err = embedIssue(ctx, s.Embeddings, s.EmbeddingModel, issue)
+		if err != nil {
+			return s.serverError(fmt.Errorf("embed issue: %w", err))
+		}
+	} else {
+		sq.Embedding, err = s.queryEmbedding(ctx, query)
+		if err != nil {
+			return s.serverError(fmt.Errorf("embed query: %w", err))
+		}
+	}
+
+	results := []searchResult{}
+	if len(sq.Repos) == 0 {
+		// The caller can't read any of the installation's repos.
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"results": results},
+		}
+	}
+	found, err := s.Issues.Search(ctx, sq)
+	if err != nil {
+		return s.serverError(fmt.Errorf("search issues: %w", err))
+	}
+	for _, f := range found {
+		results = append(results, searchResult{
+			Repo:       f.User + "/" + f.Repo,
+			Number:     f.Number,
+			Title:      f.Title,
+			State:      f.State,
+			URL:        fmt.Sprintf("https://github.com/%s/%s/issues/%d", f.User, f.Repo, f.Number),
+			Similarity: 1 - f.Distance,
+		})
+	}
+	return &httpjson.Response{
+		Status: http.StatusOK,
+		Body:   httpjson.M{"results": results},
+	}
+}
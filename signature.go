@@ -0,0 +1,42 @@
+package labeler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var (
+	errMissingSignature = errors.New("missing X-Hub-Signature-256 header")
+	errInvalidSignature = errors.New("invalid X-Hub-Signature-256 signature")
+)
+
+// verifySignature checks the X-Hub-Signature-256 header of a webhook
+// delivery against every configured secret. Accepting more than one secret
+// lets us rotate the webhook secret without dropping deliveries: GitHub signs
+// with the new secret as soon as it's saved, while we may still be running
+// with the old one.
+func verifySignature(secrets []string, payload []byte, header string) error {
+	if header == "" {
+		return errMissingSignature
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil || !strings.HasPrefix(header, "sha256=") {
+		return errInvalidSignature
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(payload)
+		if hmac.Equal(got, mac.Sum(nil)) {
+			return nil
+		}
+	}
+	return errInvalidSignature
+}
@@ -0,0 +1,141 @@
+package labeler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var signatureTests = []struct {
+	name    string
+	secrets []string
+	payload []byte
+	header  string
+	want    error
+}{
+	{
+		name:    "Valid",
+		secrets: []string{"current"},
+		payload: []byte(`{"action":"opened"}`),
+		header:  sign("current", []byte(`{"action":"opened"}`)),
+	},
+	{
+		name:    "PreviousDuringRotation",
+		secrets: []string{"current", "previous"},
+		payload: []byte(`{"action":"opened"}`),
+		header:  sign("previous", []byte(`{"action":"opened"}`)),
+	},
+	{
+		name:    "Missing",
+		secrets: []string{"current"},
+		payload: []byte(`{"action":"opened"}`),
+		want:    errMissingSignature,
+	},
+	{
+		name:    "StaleSecret",
+		secrets: []string{"current", ""},
+		payload: []byte(`{"action":"opened"}`),
+		header:  sign("retired", []byte(`{"action":"opened"}`)),
+		want:    errInvalidSignature,
+	},
+	{
+		name:    "Tampered",
+		secrets: []string{"current"},
+		payload: []byte(`{"action":"opened","installation":{"id":1}}`),
+		header:  sign("current", []byte(`{"action":"opened"}`)),
+		want:    errInvalidSignature,
+	},
+	{
+		name:    "NotHex",
+		secrets: []string{"current"},
+		payload: []byte(`{}`),
+		header:  "sha256=zz",
+		want:    errInvalidSignature,
+	},
+	{
+		name:    "WrongAlgorithm",
+		secrets: []string{"current"},
+		payload: []byte(`{}`),
+		header:  "sha1=" + sign("current", []byte(`{}`))[len("sha256="):],
+		want:    errInvalidSignature,
+	},
+}
+
+func TestVerifySignature(t *testing.T) {
+	t.Parallel()
+	for _, tt := range signatureTests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := verifySignature(tt.secrets, tt.payload, tt.header)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	t.Parallel()
+	for _, tt := range signatureTests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			wh := &Webhook{
+				Log:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+				WebhookSecrets: tt.secrets,
+			}
+			mux := chi.NewMux()
+			wh.Init(mux)
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(tt.payload))
+			// An event the labeler ignores, so a valid delivery stops
+			// after verification.
+			req.Header.Set("X-GitHub-Event", "star")
+			if tt.header != "" {
+				req.Header.Set("X-Hub-Signature-256", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			want := http.StatusOK
+			if tt.want != nil {
+				want = http.StatusUnauthorized
+			}
+			if rec.Code != want {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestWebhookNoSecret(t *testing.T) {
+	t.Parallel()
+	wh := &Webhook{Log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	mux := chi.NewMux()
+	wh.Init(mux)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("X-GitHub-Event", "star")
+	req.Header.Set("X-Hub-Signature-256", sign("", []byte(`{}`)))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,85 @@
+package labeler
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	// Registers the sqlite3 driver. It needs cgo.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite opens the SQLite database at path, creating its directory,
+// and applies schema, which must be safe to apply again.
+func openSQLite(path, schema string) (*sql.DB, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return nil, err
+	}
+	// WAL lets reads run alongside a write, and the busy timeout makes a
+	// second writer wait instead of failing.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(schema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: apply schema: %w", path, err)
+	}
+	return db, nil
+}
+
+// jsonColumn stores *v as JSON text.
+type jsonColumn[T any] struct {
+	v *T
+}
+
+func (c jsonColumn[T]) Value() (driver.Value, error) {
+	data, err := json.Marshal(*c.v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (c jsonColumn[T]) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		var zero T
+		*c.v = zero
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), c.v)
+	case []byte:
+		return json.Unmarshal(src, c.v)
+	default:
+		return fmt.Errorf("scan %T as JSON", src)
+	}
+}
+
+// encodeEmbedding packs an embedding as little-endian float64s, a fraction
+// of the size of its JSON.
+func encodeEmbedding(embedding []float64) []byte {
+	data := make([]byte, 8*len(embedding))
+	for i, f := range embedding {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(f))
+	}
+	return data
+}
+
+func decodeEmbedding(data []byte) ([]float64, error) {
+	if len(data)%8 != 0 {
+		return nil, fmt.Errorf("embedding of %d bytes", len(data))
+	}
+	embedding := make([]float64, len(data)/8)
+	for i := range embedding {
+		embedding[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+	}
+	return embedding, nil
+}
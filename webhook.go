@@ -1,29 +1,33 @@
 package labeler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ammario/tlru"
 	"github.com/beatlabs/github-auth/app"
 	"github.com/coder/labeler/ghapi"
 	"github.com/coder/labeler/httpjson"
+	"github.com/coder/labeler/llm"
+	"github.com/coder/labeler/queue"
 	"github.com/coder/retry"
 	"github.com/go-chi/chi/v5"
 	githook "github.com/go-playground/webhooks/v6/github"
 	"github.com/google/go-github/v59/github"
 	"github.com/sashabaranov/go-openai"
 	"golang.org/x/exp/maps"
-	"gopkg.in/yaml.v3"
 )
 
 type repoAddr struct {
@@ -32,32 +36,137 @@ type repoAddr struct {
 
 type Webhook struct {
 	Log       *slog.Logger
-	OpenAI    *openai.Client
+	LLM       llm.Provider
 	AppConfig *app.Config
 	Model     string
 
+	// WebhookSecrets are the secrets used to verify the signature of
+	// incoming webhook deliveries. More than one secret may be provided
+	// to support rotation. Deliveries are rejected if none are set.
+	WebhookSecrets []string
+
+	// APITokenSecret signs the API tokens accepted by /infer. If empty,
+	// only GitHub user tokens are accepted.
+	APITokenSecret string
+
+	// Feedback, if set, records the labels the bot applies and human
+	// corrections of them.
+	Feedback FeedbackStore
+
+	// Audit, if set, records every labeling decision.
+	Audit AuditStore
+
+	// Issues and Embeddings, if set, find issues similar to the target
+	// among the Indexer's, for similar examples, duplicate suggestions and
+	// /search. EmbeddingModel must match the Indexer's.
+	Issues         IssueStore
+	Embeddings     llm.Provider
+	EmbeddingModel string
+
+	// Queue, if set, receives webhook jobs so deliveries can be
+	// acknowledged right away. RunWorkers must be called to process
+	// them. If nil, jobs run within the webhook request.
+	Queue *queue.Queue
+	// OAuthClientID and OAuthClientSecret are the GitHub App's OAuth
+	// credentials, used to sign in to the dashboard. The dashboard is
+	// disabled without them.
+	OAuthClientID     string
+	OAuthClientSecret string
+
 	router *chi.Mux
+	auth   *authenticator
+
+	// deliveries tracks X-GitHub-Delivery IDs so that redeliveries of an
+	// event we've already handled, or are still handling, are skipped.
+	deliveries   *tlru.Cache[string, deliveryState]
+	deliveriesMu sync.Mutex
+
+	// botLoginValue memoizes botLogin.
+	botLoginValue string
+	botLoginMu    sync.Mutex
 
 	// These caches are primarily useful in the test system, where there are
 	// many inference requests to the same repo in a short period of time.
 	//
-	// That will rarely happen in production.
-	repoLabelsCache *tlru.Cache[repoAddr, []*github.Label]
+	// In production, they are evicted by label and issue webhook events so
+	// inference never sees stale labels. Their stats are served at
+	// /debug/caches.
+	repoLabelsCache *statCache[repoAddr, []*github.Label]
+
+	recentIssuesCache *statCache[repoAddr, []*github.Issue]
+
+	recentPullsCache *statCache[repoAddr, []*github.Issue]
+
+	// configCache holds raw config files keyed by configKey. It is
+	// evicted by push events that touch the file.
+	configCache *statCache[string, string]
+
+	// sessions maps dashboard session IDs to signed in users.
+	sessions *tlru.Cache[string, *dashboardSession]
+
+	// inscriptiveCache holds the inscriptive labels of each repo, found
+	// from its label history.
+	inscriptiveCache *statCache[repoAddr, map[string]string]
 
-	recentIssuesCache *tlru.Cache[repoAddr, []*github.Issue]
+	// queryEmbeddingCache holds the embeddings of /search queries, keyed
+	// by model and query.
+	queryEmbeddingCache *statCache[string, []float64]
 }
 
 func (s *Webhook) Init(r *chi.Mux) {
 	s.router = r
-	s.router.Mount("/infer", httpjson.Handler(s.infer))
+	s.auth = newAuthenticator(s.Log, []byte(s.APITokenSecret), s.repoInstallation)
+	s.router.With(
+		s.auth.Authenticate,
+		s.auth.RequireRepoRead,
+	).Mount("/infer", httpjson.Handler(s.infer))
+	s.router.With(
+		s.auth.Authenticate,
+		s.auth.RequireRepoRead,
+	).Mount("/accuracy", httpjson.Handler(s.accuracy))
+	s.router.With(
+		s.auth.Authenticate,
+		s.auth.RequireRepoRead,
+	).Mount("/lint", httpjson.Handler(s.lint))
+	s.router.With(
+		s.auth.Authenticate,
+		s.auth.RequireRepoRead,
+	).Mount("/decisions", httpjson.Handler(s.listDecisions))
+	// /search authorizes each repo it searches itself.
+	s.router.With(
+		s.auth.Authenticate,
+	).Mount("/search", httpjson.Handler(s.search))
 	s.router.Mount("/webhook", httpjson.Handler(s.webhook))
 
-	s.repoLabelsCache = tlru.New[repoAddr](func(ls []*github.Label) int {
+	s.router.With(
+		s.auth.Authenticate,
+		s.auth.RequireOperator,
+	).Mount("/debug/caches", httpjson.Handler(s.cacheStats))
+	if s.OAuthClientID != "" {
+		s.initDashboard()
+	}
+
+	s.repoLabelsCache = newStatCache[repoAddr](func(ls []*github.Label) int {
+		return len(ls)
+	}, 4096)
+	s.recentIssuesCache = newStatCache[repoAddr](func(ls []*github.Issue) int {
 		return len(ls)
 	}, 4096)
-	s.recentIssuesCache = tlru.New[repoAddr](func(ls []*github.Issue) int {
+	s.recentPullsCache = newStatCache[repoAddr](func(ls []*github.Issue) int {
 		return len(ls)
 	}, 4096)
+	s.configCache = newStatCache[string](func(string) int {
+		return 1
+	}, 4096)
+	s.inscriptiveCache = newStatCache[repoAddr](func(map[string]string) int {
+		return 1
+	}, 4096)
+	s.queryEmbeddingCache = newStatCache[string](func([]float64) int {
+		return 1
+	}, 4096)
+	s.deliveries = tlru.New[string](func(deliveryState) int {
+		return 1
+	}, 1<<16)
 }
 
 func filterIssues(slice []*github.Issue, f func(*github.Issue) bool) []*github.Issue {
@@ -76,70 +185,138 @@ type InferRequest struct {
 	// TestMode determines whether the target issue's existing labels
 	// are stripped before inference.
 	TestMode bool `json:"test_mode"`
+
+	// applying is set by callers that act on the response. They record
+	// the decision themselves once they know which labels they applied.
+	applying bool
 }
 
 type InferResponse struct {
 	SetLabels      []string `json:"set_labels,omitempty"`
 	TokensUsed     int      `json:"tokens_used,omitempty"`
 	DisabledLabels []string `json:"disabled_labels,omitempty"`
+	// DisabledReasons says why each of DisabledLabels is disabled.
+	DisabledReasons map[string]string `json:"disabled_reasons,omitempty"`
+	// Reasoning is the model's explanation of the labels it chose, before
+	// any filtering.
+	Reasoning string `json:"reasoning,omitempty"`
+	// LabelReasons is the model's reason for each label it chose.
+	LabelReasons map[string]string `json:"label_reasons,omitempty"`
+	// Confidence is the model's confidence in each label it chose, from
+	// 0 to 1, derived from token log probabilities. It is empty if the
+	// model didn't return log probabilities.
+	Confidence map[string]float64 `json:"confidence,omitempty"`
+	// RemoveLabels are existing labels that SetLabels replace, as allowed
+	// by exclusive groups.
+	RemoveLabels []string `json:"remove_labels,omitempty"`
+	// Exclusive describes how exclusive groups were resolved.
+	Exclusive []exclusiveDecision `json:"exclusive,omitempty"`
+	// RuleFirings are the implies, conflicts_with and requires_one_of
+	// rules that changed SetLabels.
+	RuleFirings []ruleFiring `json:"rule_firings,omitempty"`
+	// RuleLabels are the labels set by the repo's rules rather than the
+	// model. They are included in SetLabels unless a label rule dropped
+	// them.
+	RuleLabels []ruleLabel `json:"rule_labels,omitempty"`
+
+	// existingLabels are the labels on the target issue at the time of
+	// inference, regardless of TestMode.
+	existingLabels []string
+	// audit is the decision's audit record.
+	audit *AuditRecord
 }
 
-type repoConfig struct {
-	Exclude []regexp.Regexp `json:"exclude"`
-}
-
-func (c *repoConfig) checkLabel(label string) bool {
-	for _, re := range c.Exclude {
-		if re.MatchString(label) {
-			return false
+func filterSlice[T any](slice []T, f func(T) bool) []T {
+	var result []T
+	for _, item := range slice {
+		if f(item) {
+			result = append(result, item)
 		}
 	}
-	return true
+	return result
 }
 
-func (s *Webhook) getRepoConfig(ctx context.Context, client *github.Client,
-	owner, repo string,
-) (*repoConfig, error) {
-	fileContent, _, _, err := client.Repositories.GetContents(
-		ctx,
-		owner,
-		repo,
-		".github/labeler.yml",
-		&github.RepositoryContentGetOptions{},
-	)
+func (s *Webhook) getRepoLabels(ctx context.Context, client *github.Client,
+	addr repoAddr,
+) ([]*github.Label, error) {
+	labels, err := s.repoLabelsCache.Do(addr, func() ([]*github.Label, error) {
+		return ghapi.Page(
+			ctx,
+			client,
+			func(ctx context.Context, opt *github.ListOptions) ([]*github.Label, *github.Response, error) {
+				return client.Issues.ListLabels(ctx, addr.User, addr.Repo, opt)
+			},
+			// We use the coder/customers label count as a reasonable maximum.
+			300,
+		)
+	}, time.Minute)
 	if err != nil {
-		var githubErr *github.ErrorResponse
-		if errors.As(err, &githubErr) && githubErr.Response.StatusCode == http.StatusNotFound {
-			return &repoConfig{}, nil
-		}
-		return nil, fmt.Errorf("get contents: %w", err)
+		return nil, fmt.Errorf("list labels: %w", err)
 	}
+	return labels, nil
+}
 
-	content, err := fileContent.GetContent()
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal content: %w", err)
+// disabledLabels maps each label that may not be set on target to why.
+// If target is nil, only the checks that don't depend on it are run.
+func (s *Webhook) disabledLabels(ctx context.Context, client *github.Client,
+	addr repoAddr, config *repoConfig, repoLabels []*github.Label, target *github.Issue,
+) map[string]string {
+	disabled := make(map[string]string)
+	for _, label := range repoLabels {
+		if strings.Contains(label.GetDescription(), magicDisableString) {
+			disabled[label.GetName()] = "description says only humans may set it"
+		}
+		if !config.checkLabel(label.GetName()) {
+			disabled[label.GetName()] = "excluded by config"
+		}
+		if target == nil {
+			continue
+		}
+		if !config.checkTarget(label.GetName(), target.IsPullRequest()) {
+			disabled[label.GetName()] = "pull_requests config"
+		}
+		if !config.checkPolicy(label.GetName(), target.IsPullRequest(), target.GetAuthorAssociation()) {
+			disabled[label.GetName()] = "label policy"
+		}
 	}
-
-	var config repoConfig
-	err = yaml.Unmarshal(
-		[]byte(content),
-		&config,
-	)
-
-	return &config, err
+	if config.Inscriptive.AutoDisable {
+		inscriptive, err := s.getInscriptiveLabels(ctx, client, addr)
+		if err != nil {
+			// Labeling without the analysis beats not labeling.
+			s.Log.Warn("get inscriptive labels",
+				"repo", addr.User+"/"+addr.Repo,
+				"error", err,
+			)
+		}
+		for label, reason := range inscriptive {
+			if _, ok := disabled[label]; !ok {
+				disabled[label] = reason
+			}
+		}
+	}
+	return disabled
 }
 
-func filterSlice[T any](slice []T, f func(T) bool) []T {
-	var result []T
-	for _, item := range slice {
-		if f(item) {
-			result = append(result, item)
+// complete creates a chat completion, retrying server errors and rate
+// limits.
+func (s *Webhook) complete(ctx context.Context,
+	req openai.ChatCompletionRequest,
+) (openai.ChatCompletionResponse, error) {
+	ret := retry.New(time.Second, time.Second*10)
+retryAI:
+	resp, err := s.LLM.Complete(ctx, req)
+	if err != nil {
+		if llm.Retryable(err) && ret.Wait(ctx) {
+			s.Log.Warn("retrying AI call", "error", err)
+			goto retryAI
 		}
+		return resp, fmt.Errorf("create chat completion: %w", err)
 	}
-	return result
+	return resp, nil
 }
 
 func (s *Webhook) Infer(ctx context.Context, req *InferRequest) (*InferResponse, error) {
+	start := time.Now()
 	instConfig, err := s.AppConfig.InstallationConfig(req.InstallID)
 	if err != nil {
 		return nil, fmt.Errorf("get installation config: %w", err)
@@ -152,11 +329,28 @@ func (s *Webhook) Infer(ctx context.Context, req *InferRequest) (*InferResponse,
 		return nil, fmt.Errorf("get repo config: %w", err)
 	}
 
-	lastIssues, err := s.recentIssuesCache.Do(repoAddr{
+	targetIssue, _, err := githubClient.Issues.Get(ctx, req.User, req.Repo, req.Issue)
+	if err != nil {
+		return nil, fmt.Errorf("get target issue: %w", err)
+	}
+	isPR := targetIssue.IsPullRequest()
+	if isPR && !config.PullRequests.Enabled {
+		return nil, fmt.Errorf("pull request labeling is disabled in %s/%s", req.User, req.Repo)
+	}
+
+	addr := repoAddr{
 		InstallID: req.InstallID,
 		User:      req.User,
 		Repo:      req.Repo,
-	}, func() ([]*github.Issue, error) {
+	}
+
+	// Pull requests are labeled from past pull requests, and issues from
+	// past issues, since the two rarely share a label vocabulary.
+	examplesCache, onlyKind := s.recentIssuesCache, ghapi.OnlyTrueIssues
+	if isPR {
+		examplesCache, onlyKind = s.recentPullsCache, ghapi.OnlyPullRequests
+	}
+	lastIssues, err := examplesCache.Do(addr, func() ([]*github.Issue, error) {
 		return ghapi.Page(
 			ctx,
 			githubClient,
@@ -171,7 +365,7 @@ func (s *Webhook) Infer(ctx context.Context, req *InferRequest) (*InferResponse,
 					},
 				)
 
-				return ghapi.OnlyTrueIssues(issues), resp, err
+				return onlyKind(issues), resp, err
 			},
 			100,
 		)
@@ -180,28 +374,9 @@ func (s *Webhook) Infer(ctx context.Context, req *InferRequest) (*InferResponse,
 		return nil, fmt.Errorf("list issues: %w", err)
 	}
 
-	repoLabels, err := s.repoLabelsCache.Do(repoAddr{
-		InstallID: req.InstallID,
-		User:      req.User,
-		Repo:      req.Repo,
-	}, func() ([]*github.Label, error) {
-		return ghapi.Page(
-			ctx,
-			githubClient,
-			func(ctx context.Context, opt *github.ListOptions) ([]*github.Label, *github.Response, error) {
-				return githubClient.Issues.ListLabels(ctx, req.User, req.Repo, opt)
-			},
-			// We use the coder/customers label count as a reasonable maximum.
-			300,
-		)
-	}, time.Minute)
-	if err != nil {
-		return nil, fmt.Errorf("list labels: %w", err)
-	}
-
-	targetIssue, _, err := githubClient.Issues.Get(ctx, req.User, req.Repo, req.Issue)
+	repoLabels, err := s.getRepoLabels(ctx, githubClient, addr)
 	if err != nil {
-		return nil, fmt.Errorf("get target issue: %w", err)
+		return nil, err
 	}
 
 	// Take out target issue from the list of issues
@@ -216,31 +391,71 @@ func (s *Webhook) Infer(ctx context.Context, req *InferRequest) (*InferResponse,
 		return iTime.Before(jTime)
 	})
 
+	var existingLabels []string
+	for _, label := range targetIssue.Labels {
+		existingLabels = append(existingLabels, label.GetName())
+	}
+
+	// In test mode the issue is labeled as if it had no labels.
+	currentLabels := existingLabels
 	if req.TestMode {
 		targetIssue.Labels = nil
+		currentLabels = nil
 	}
 
+	if config.Examples.Mode == examplesSimilar {
+		lastIssues = s.mixExamples(ctx, githubClient, addr, &config.Examples, targetIssue, lastIssues)
+	}
+
+	repoLabelsMap := make(map[string]struct{})
+	for _, label := range repoLabels {
+		repoLabelsMap[label.GetName()] = struct{}{}
+	}
+
+	log := s.Log.With(
+		"repo", req.User+"/"+req.Repo,
+		"issue", req.Issue,
+	)
+
+	// Mechanical labels come from the repo's rules rather than the model.
+	ruleLabels := filterSlice(
+		applyAssignRules(config.Rules, targetIssue, currentLabels),
+		func(rl ruleLabel) bool {
+			_, ok := repoLabelsMap[rl.Label]
+			if !ok {
+				log.Warn("rule label not found", "label", rl.Label, "rule", rl.Rule)
+			}
+			return ok
+		},
+	)
+
 	aiContext := &aiContext{
 		allLabels:   repoLabels,
 		lastIssues:  lastIssues,
 		targetIssue: targetIssue,
+		guidance:    make(map[string]string),
+
+		instructions: config.instructionsText(),
+	}
+	for _, rl := range ruleLabels {
+		aiContext.ruleLabels = append(aiContext.ruleLabels, rl.Label)
+	}
+	for _, label := range repoLabels {
+		if lc, ok := config.labelPolicy(label.GetName()); ok && lc.Guidance != "" {
+			aiContext.guidance[label.GetName()] = lc.Guidance
+		}
+	}
+	if isPR {
+		aiContext.pullRequest, err = s.getPullRequestContext(ctx, githubClient, req.User, req.Repo, req.Issue)
+		if err != nil {
+			return nil, fmt.Errorf("get pull request context: %w", err)
+		}
 	}
 
-retryAI:
-	ret := retry.New(time.Second, time.Second*10)
-	resp, err := s.OpenAI.CreateChatCompletion(
-		ctx,
-		aiContext.Request(s.Model),
-	)
+	aiReq := aiContext.Request(s.Model)
+	resp, err := s.complete(ctx, aiReq)
 	if err != nil {
-		var aiErr *openai.APIError
-		if errors.As(err, &aiErr) {
-			if (aiErr.HTTPStatusCode >= 500 || aiErr.HTTPStatusCode == 429) && ret.Wait(ctx) {
-				s.Log.Warn("retrying AI call", "error", err)
-				goto retryAI
-			}
-		}
-		return nil, fmt.Errorf("create chat completion: %w", err)
+		return nil, err
 	}
 	if len(resp.Choices) != 1 {
 		return nil, fmt.Errorf("expected one choice")
@@ -250,41 +465,54 @@ retryAI:
 
 	content := choice.Message.Content
 	var setLabels struct {
-		Reasoning string   `json:"reasoning"`
-		Labels    []string `json:"labels"`
+		Reasoning    string   `json:"reasoning"`
+		Labels       []string `json:"labels"`
+		LabelReasons []struct {
+			Label  string `json:"label"`
+			Reason string `json:"reason"`
+		} `json:"label_reasons"`
 	}
 
 	err = json.Unmarshal([]byte(content), &setLabels)
 	if err != nil {
 		return nil, fmt.Errorf("unmarshal setLabels: %w, content: %q", err, content)
 	}
-	s.Log.Info("set labels", "labels", setLabels.Labels, "reasoning", setLabels.Reasoning)
+	var confidences map[string]float64
+	if choice.LogProbs != nil {
+		confidences = labelConfidences(content, choice.LogProbs.Content)
+	}
+	s.Log.Info("set labels",
+		"labels", setLabels.Labels,
+		"reasoning", setLabels.Reasoning,
+		"confidence", confidences,
+	)
 
-	disabledLabels := make(map[string]struct{})
-	for _, label := range repoLabels {
-		if strings.Contains(label.GetDescription(), magicDisableString) {
-			disabledLabels[label.GetName()] = struct{}{}
-		}
-		if !config.checkLabel(label.GetName()) {
-			disabledLabels[label.GetName()] = struct{}{}
-		}
+	disabledLabels := s.disabledLabels(ctx, githubClient, addr, config, repoLabels, targetIssue)
+
+	audit := &AuditRecord{
+		InstallID:        req.InstallID,
+		User:             req.User,
+		Repo:             req.Repo,
+		Issue:            req.Issue,
+		PullRequest:      isPR,
+		TestMode:         req.TestMode,
+		Model:            aiReq.Model,
+		PromptHash:       promptHash(aiReq.Messages),
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+		RawOutput:        content,
+		ModelLabels:      setLabels.Labels,
 	}
 
-	// Remove any labels that are disabled.
+	// Remove any labels that are disabled, or that rules already set.
 	newLabels := filterSlice(setLabels.Labels, func(label string) bool {
 		_, ok := disabledLabels[label]
-		return !ok
+		return !ok && !slices.ContainsFunc(ruleLabels, func(rl ruleLabel) bool {
+			return rl.Label == label
+		})
 	})
+	audit.AfterDisabled = newLabels
 
-	repoLabelsMap := make(map[string]struct{})
-	for _, label := range repoLabels {
-		repoLabelsMap[label.GetName()] = struct{}{}
-	}
-
-	log := s.Log.With(
-		"repo", req.User+"/"+req.Repo,
-		"issue", req.Issue,
-	)
 	// Remove any labels that are not defined by the repo.
 	// Sometimes the model returns labels in a
 	// space delimited string. For example, "bug critical" instead of
@@ -297,12 +525,124 @@ retryAI:
 		}
 		return ok
 	})
+	audit.AfterRepo = newLabels
+
+	// Remove any labels the model isn't confident enough in.
+	if confidences != nil {
+		newLabels = filterSlice(newLabels, func(label string) bool {
+			conf, minConf := confidences[label], config.minConfidence(label)
+			if conf < minConf {
+				log.Info("label below confidence threshold",
+					"label", label,
+					"confidence", conf,
+					"min_confidence", minConf,
+				)
+				return false
+			}
+			return true
+		})
+	} else if config.MinConfidence > 0 || len(config.Labels) > 0 {
+		log.Warn("no log probabilities, skipping confidence thresholds")
+	}
+	audit.AfterConfidence = newLabels
+
+	// Rule labels bypass the filters above and win over the model's in
+	// the label rules that follow, as if the model were certain of them.
+	ranked := make(map[string]float64, len(confidences)+len(ruleLabels))
+	for label, conf := range confidences {
+		ranked[label] = conf
+	}
+	for i := len(ruleLabels) - 1; i >= 0; i-- {
+		newLabels = append([]string{ruleLabels[i].Label}, newLabels...)
+		ranked[ruleLabels[i].Label] = 1
+	}
+
+	allowed := func(label string) bool {
+		_, disabled := disabledLabels[label]
+		_, ok := repoLabelsMap[label]
+		return ok && !disabled
+	}
+
+	// Apply the label rules.
+	var firings, fired []ruleFiring
+	newLabels, fired = applyImplies(config.Implies, newLabels, allowed)
+	firings = append(firings, fired...)
+	newLabels, fired = applyConflicts(config.ConflictsWith, newLabels, ranked, currentLabels)
+	firings = append(firings, fired...)
+
+	newLabels, removeLabels, exclusive := applyExclusive(
+		config.Exclusive, newLabels, ranked, currentLabels,
+	)
+	for _, d := range exclusive {
+		log.Info("resolved exclusive group",
+			"group", d.Group,
+			"kept", d.Kept,
+			"dropped", d.Dropped,
+			"removed", d.Removed,
+		)
+	}
+
+	tokensUsed := resp.Usage.TotalTokens
+	for _, group := range missingRequired(config.RequiresOneOf, newLabels, currentLabels) {
+		choices := filterSlice(group, allowed)
+		if len(choices) == 0 {
+			log.Warn("no allowed labels in required group", "group", group)
+			continue
+		}
+		label, tokens, err := s.pickLabel(ctx, aiContext, choices)
+		if err != nil {
+			return nil, fmt.Errorf("pick one of %v: %w", choices, err)
+		}
+		tokensUsed += tokens
+		newLabels = append(newLabels, label)
+		firings = append(firings, ruleFiring{
+			Rule:    "requires_one_of",
+			Label:   label,
+			Action:  ruleActionAdded,
+			Because: group,
+		})
+	}
+	for _, f := range firings {
+		log.Info("label rule fired",
+			"rule", f.Rule,
+			"label", f.Label,
+			"action", f.Action,
+			"because", f.Because,
+		)
+	}
+
+	audit.AfterRules = newLabels
+	for _, rl := range ruleLabels {
+		audit.RuleLabels = append(audit.RuleLabels, rl.Label)
+	}
+	audit.TotalTokens = tokensUsed
+	audit.At = time.Now()
+	audit.LatencyMS = audit.At.Sub(start).Milliseconds()
+
+	labelReasons := make(map[string]string)
+	for _, lr := range setLabels.LabelReasons {
+		labelReasons[lr.Label] = lr.Reason
+	}
 
-	return &InferResponse{
-		SetLabels:      newLabels,
-		TokensUsed:     resp.Usage.TotalTokens,
-		DisabledLabels: maps.Keys(disabledLabels),
-	}, nil
+	inferResp := &InferResponse{
+		SetLabels:       newLabels,
+		TokensUsed:      tokensUsed,
+		DisabledLabels:  maps.Keys(disabledLabels),
+		DisabledReasons: disabledLabels,
+		Reasoning:       setLabels.Reasoning,
+		LabelReasons:    labelReasons,
+		Confidence:      confidences,
+		RemoveLabels:    removeLabels,
+		Exclusive:       exclusive,
+		RuleFirings:     firings,
+		RuleLabels:      ruleLabels,
+		existingLabels:  existingLabels,
+		audit:           audit,
+	}
+	if !req.applying {
+		s.recordDecision(ctx, audit)
+	}
+	return inferResp, nil
 }
 
 func (s *Webhook) infer(w http.ResponseWriter, r *http.Request) *httpjson.Response {
@@ -344,6 +684,15 @@ func (s *Webhook) infer(w http.ResponseWriter, r *http.Request) *httpjson.Respon
 	}
 }
 
+// repoInstallation returns the ID of the app's installation on a repo.
+func (s *Webhook) repoInstallation(ctx context.Context, owner, repo string) (int64, error) {
+	inst, _, err := github.NewClient(s.AppConfig.Client()).Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		return 0, err
+	}
+	return inst.GetID(), nil
+}
+
 func (s *Webhook) serverError(msg error) *httpjson.Response {
 	s.Log.Error("server error", "error", msg)
 	return &httpjson.Response{
@@ -353,6 +702,57 @@ func (s *Webhook) serverError(msg error) *httpjson.Response {
 }
 
 func (s *Webhook) webhook(w http.ResponseWriter, r *http.Request) *httpjson.Response {
+	if len(s.WebhookSecrets) == 0 {
+		return s.serverError(errors.New("no webhook secret configured"))
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return &httpjson.Response{
+			Status: http.StatusBadRequest,
+			Body:   httpjson.M{"error": "read body: " + err.Error()},
+		}
+	}
+
+	err = verifySignature(s.WebhookSecrets, body, r.Header.Get("X-Hub-Signature-256"))
+	if err != nil {
+		s.Log.Warn("rejected webhook delivery",
+			"error", err,
+			"delivery", r.Header.Get("X-GitHub-Delivery"),
+			"remote_addr", r.RemoteAddr,
+		)
+		return httpjson.ErrorMessage(http.StatusUnauthorized, err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	delivery := r.Header.Get("X-GitHub-Delivery")
+	if delivery != "" {
+		if state, ok := s.claimDelivery(delivery); !ok {
+			s.Log.Info("skipping duplicate delivery",
+				"delivery", delivery,
+				"state", state,
+				"event", r.Header.Get("X-GitHub-Event"),
+			)
+			return &httpjson.Response{
+				Status: http.StatusOK,
+				Body: httpjson.M{
+					"message":  "duplicate delivery",
+					"delivery": delivery,
+					"state":    state,
+				},
+			}
+		}
+	}
+
+	resp := s.handleEvent(r, body)
+	// Queued deliveries are finished by their job.
+	if resp.Status != http.StatusAccepted {
+		s.finishDelivery(delivery, resp.Status < 400)
+	}
+	return resp
+}
+
+func (s *Webhook) handleEvent(r *http.Request, body []byte) *httpjson.Response {
 	hook, err := githook.New()
 	if err != nil {
 		if errors.Is(err, githook.ErrEventNotSpecifiedToParse) {
@@ -365,76 +765,193 @@ func (s *Webhook) webhook(w http.ResponseWriter, r *http.Request) *httpjson.Resp
 	}
 
 	payloadAny, err := hook.Parse(
-		r, githook.IssuesEvent,
+		r,
+		githook.IssuesEvent,
+		githook.PullRequestEvent,
+		githook.LabelEvent,
+		githook.IssueCommentEvent,
+		githook.PushEvent,
 	)
 	if err != nil {
+		if errors.Is(err, githook.ErrEventNotFound) {
+			return &httpjson.Response{
+				Status: http.StatusOK,
+				Body:   httpjson.M{"message": "ignoring event: " + r.Header.Get("X-GitHub-Event")},
+			}
+		}
 		return s.serverError(err)
 	}
 
-	payload, ok := payloadAny.(githook.IssuesPayload)
-	if !ok {
-		return s.serverError(fmt.Errorf("expected issues payload: %T", payloadAny))
-	}
-
-	if payload.Action != "opened" && payload.Action != "reopened" {
-		return &httpjson.Response{
-			Status: http.StatusOK,
-			Body:   httpjson.M{"message": "not an opened issue"},
-		}
+	switch payload := payloadAny.(type) {
+	case githook.IssuesPayload:
+		return s.issuesEvent(r, payload)
+	case githook.PullRequestPayload:
+		return s.pullRequestEvent(r, payload)
+	case githook.LabelPayload:
+		return s.labelEvent(payload, body)
+	case githook.IssueCommentPayload:
+		return s.issueCommentEvent(r, payload)
+	case githook.PushPayload:
+		return s.pushEvent(body)
+	default:
+		return s.serverError(fmt.Errorf("unexpected payload: %T", payloadAny))
 	}
+}
 
+func (s *Webhook) issuesEvent(r *http.Request, payload githook.IssuesPayload) *httpjson.Response {
 	repo := payload.Repository
 
-	resp, err := s.Infer(r.Context(), &InferRequest{
+	job := labelJob{
 		InstallID: strconv.FormatInt(payload.Installation.ID, 10),
 		User:      repo.Owner.Login,
 		Repo:      repo.Name,
 		Issue:     int(payload.Issue.Number),
-	})
-	if err != nil {
-		return s.serverError(fmt.Errorf("infer: %w, issue: %+v", err, payload.Issue.URL))
+		URL:       payload.Issue.HTMLURL,
 	}
 
-	if len(resp.SetLabels) == 0 {
+	switch payload.Action {
+	case "opened", "reopened":
+		job.Opened = payload.Action == "opened"
+	case "labeled", "unlabeled":
+		addr := repoAddr{
+			InstallID: job.InstallID,
+			User:      job.User,
+			Repo:      job.Repo,
+		}
+		// Past issues are examples for inference, so their labels
+		// must be fresh.
+		s.recentIssuesCache.Delete(addr)
+		if payload.Sender.Type != "Bot" && payload.Label != nil {
+			err := s.recordLabelChange(r.Context(), addr, job.Issue,
+				payload.Label.Name, payload.Action == "labeled", payload.Sender.Login,
+			)
+			if err != nil {
+				return s.serverError(fmt.Errorf("record label change: %w", err))
+			}
+		}
 		return &httpjson.Response{
 			Status: http.StatusOK,
-			Body:   httpjson.M{"message": "no labels to set"},
+			Body:   httpjson.M{"message": "evicted recent issues"},
+		}
+	case "edited":
+		s.recentIssuesCache.Delete(repoAddr{
+			InstallID: job.InstallID,
+			User:      job.User,
+			Repo:      job.Repo,
+		})
+		changes := payload.Changes
+		if changes == nil || (changes.Title == nil && changes.Body == nil) {
+			return &httpjson.Response{
+				Status: http.StatusOK,
+				Body:   httpjson.M{"message": "title and body unchanged"},
+			}
+		}
+		beforeTitle, beforeBody := payload.Issue.Title, payload.Issue.Body
+		if changes.Title != nil {
+			beforeTitle = changes.Title.From
+		}
+		if changes.Body != nil {
+			beforeBody = changes.Body.From
+		}
+		job.Edited = true
+		job.Change = textChange(
+			beforeTitle+"\n"+beforeBody,
+			payload.Issue.Title+"\n"+payload.Issue.Body,
+		)
+	default:
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"message": "not an opened or edited issue"},
 		}
 	}
 
-	// Set the labels.
-	instConfig, err := s.AppConfig.InstallationConfig(strconv.FormatInt(payload.Installation.ID, 10))
-	if err != nil {
-		return s.serverError(err)
+	return s.dispatch(r, jobKindLabel, job)
+}
+
+func (s *Webhook) pullRequestEvent(r *http.Request, payload githook.PullRequestPayload) *httpjson.Response {
+	if payload.Action == "labeled" || payload.Action == "unlabeled" {
+		addr := repoAddr{
+			InstallID: strconv.FormatInt(payload.Installation.ID, 10),
+			User:      payload.Repository.Owner.Login,
+			Repo:      payload.Repository.Name,
+		}
+		s.recentPullsCache.Delete(addr)
+		if payload.Sender.Type != "Bot" {
+			err := s.recordLabelChange(r.Context(), addr, int(payload.Number),
+				payload.Label.Name, payload.Action == "labeled", payload.Sender.Login,
+			)
+			if err != nil {
+				return s.serverError(fmt.Errorf("record label change: %w", err))
+			}
+		}
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"message": "evicted recent pull requests"},
+		}
 	}
 
-	githubClient := github.NewClient(instConfig.Client(r.Context()))
-	_, _, err = githubClient.Issues.AddLabelsToIssue(
-		r.Context(),
-		repo.Owner.Login,
-		repo.Name,
-		int(payload.Issue.Number),
-		resp.SetLabels,
-	)
+	if payload.Action != "opened" && payload.Action != "synchronize" {
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"message": "not an opened or synchronized pull request"},
+		}
+	}
+
+	repo := payload.Repository
+
+	return s.dispatch(r, jobKindLabel, labelJob{
+		InstallID:   strconv.FormatInt(payload.Installation.ID, 10),
+		User:        repo.Owner.Login,
+		Repo:        repo.Name,
+		Issue:       int(payload.Number),
+		URL:         payload.PullRequest.HTMLURL,
+		Opened:      payload.Action == "opened",
+		PullRequest: true,
+		HeadSHA:     payload.PullRequest.Head.Sha,
+	})
+}
+
+func (s *Webhook) labelEvent(payload githook.LabelPayload, body []byte) *httpjson.Response {
+	// LabelPayload doesn't carry the installation.
+	var inst struct {
+		Installation struct {
+			ID int64 `json:"id"`
+		} `json:"installation"`
+	}
+	err := json.Unmarshal(body, &inst)
 	if err != nil {
-		return s.serverError(fmt.Errorf("set %v: %w", resp.SetLabels, err))
+		return s.serverError(fmt.Errorf("unmarshal installation: %w", err))
 	}
 
-	log := s.Log.With(
-		"install_id", payload.Installation.ID,
-		"user", repo.Owner.Login,
-		"repo", repo.Name,
-		"issue_num", payload.Issue.Number,
-		"issue_url", payload.Issue.HTMLURL,
-	)
+	addr := repoAddr{
+		InstallID: strconv.FormatInt(inst.Installation.ID, 10),
+		User:      payload.Repository.Owner.Login,
+		Repo:      payload.Repository.Name,
+	}
 
-	log.Info("labels set",
-		"labels", resp.SetLabels,
-		"tokens_used", resp.TokensUsed,
+	switch payload.Action {
+	case "created", "edited", "deleted":
+	default:
+		return &httpjson.Response{
+			Status: http.StatusOK,
+			Body:   httpjson.M{"message": "ignoring label action: " + payload.Action},
+		}
+	}
+
+	s.repoLabelsCache.Delete(addr)
+	if payload.Action != "created" {
+		// Renamed or deleted labels also change how past issues read.
+		s.recentIssuesCache.Delete(addr)
+		s.recentPullsCache.Delete(addr)
+	}
+	s.Log.Debug("evicted label caches",
+		"action", payload.Action,
+		"label", payload.Label.Name,
+		"repo", addr.User+"/"+addr.Repo,
 	)
 
 	return &httpjson.Response{
 		Status: http.StatusOK,
-		Body:   httpjson.M{"message": "labels set", "labels": resp.SetLabels},
+		Body:   httpjson.M{"message": "evicted label caches"},
 	}
 }